@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+)
+
+// contextKey is the type of the keys of the values stored in the request context by the middleware
+type contextKey int
+
+const (
+	tokenKey contextKey = iota
+	metricsAccessKey
+)
+
+// TokenFromContext returns the user's token stored in the request context by the middleware,
+// e.g. to forward it to the upstream server.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	userToken, ok := ctx.Value(tokenKey).(string)
+
+	return userToken, ok
+}
+
+// MetricsAccessFromContext returns the user's access to metrics stored in the request context by the
+// MetricsAccess middleware, i.e. the map of managed clusters and namespaces returned by GetMetricsAccess.
+func MetricsAccessFromContext(ctx context.Context) (map[string][]string, bool) {
+	metricsAccess, ok := ctx.Value(metricsAccessKey).(map[string][]string)
+
+	return metricsAccess, ok
+}
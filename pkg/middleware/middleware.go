@@ -0,0 +1,201 @@
+// Package middleware provides net/http middleware to authorize requests with the rbac Access Review API,
+// e.g. in proxies of metrics queries.
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/stolostron/rbac-api-utils/pkg/rbac"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultTokenCookie is the name of the cookie the user's token is read from, when no other name
+	// is passed to WithTokenCookie
+	DefaultTokenCookie = "acm-access-token-cookie"
+	// ForwardedAccessTokenHeader is the header set by authenticating proxies, e.g. oauth-proxy,
+	// with the user's token
+	ForwardedAccessTokenHeader = "X-Forwarded-Access-Token"
+	// StatusClientClosedRequest is the non-standard status code of the requests cancelled by the client,
+	// as used by nginx
+	StatusClientClosedRequest = 499
+)
+
+// MetricsAccessReviewer returns the user's access to metrics, it is implemented by *rbac.AccessReviewer.
+type MetricsAccessReviewer interface {
+	GetMetricsAccessWithContext(ctx context.Context, userToken string, clusters ...string) (map[string][]string, error)
+}
+
+// Option configures optional behavior of the middleware, it is passed to MetricsAccess.
+type Option func(*metricsAccessHandler)
+
+// WithTokenCookie sets the name of the cookie the user's token is read from, DefaultTokenCookie is used if empty.
+func WithTokenCookie(name string) Option {
+	return func(h *metricsAccessHandler) {
+		if name == "" {
+			name = DefaultTokenCookie
+		}
+
+		h.tokenCookie = name
+	}
+}
+
+// WithClusters sets the function returning the managed clusters the access is reviewed for, from the request,
+// e.g. from a query parameter. By default, the access is reviewed for all the allowed managed clusters.
+func WithClusters(clusters func(req *http.Request) []string) Option {
+	return func(h *metricsAccessHandler) {
+		h.clusters = clusters
+	}
+}
+
+// MetricsAccess returns a middleware that authorizes the requests with the user's access to metrics.
+// The user's token is read from the "Authorization: Bearer" header, the X-Forwarded-Access-Token header or
+// the token cookie, in that order. The token and the map of managed clusters and namespaces returned
+// by GetMetricsAccess are stored in the context of the request passed to the next handler,
+// see TokenFromContext and MetricsAccessFromContext.
+//
+// If no token is set or the access can't be reviewed, a JSON error is returned: 401 if the token is missing
+// or rejected by the k8s cluster, 403 if the user is forbidden from reviewing the access or the user's rules
+// are incomplete with strict rules enabled, 504 if the review timed out, StatusClientClosedRequest if the request
+// was cancelled, e.g. the client disconnected, and 500 otherwise.
+//
+// - reviewer is usually an *rbac.AccessReviewer created with a k8s config
+//
+// - opts are optional settings for the middleware, e.g. WithClusters
+func MetricsAccess(reviewer MetricsAccessReviewer, opts ...Option) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handler := &metricsAccessHandler{
+			reviewer:    reviewer,
+			next:        next,
+			tokenCookie: DefaultTokenCookie,
+		}
+
+		for _, opt := range opts {
+			opt(handler)
+		}
+
+		return handler
+	}
+}
+
+// metricsAccessHandler is the http.Handler of the MetricsAccess middleware
+type metricsAccessHandler struct {
+	reviewer    MetricsAccessReviewer
+	next        http.Handler
+	tokenCookie string
+	clusters    func(req *http.Request) []string
+}
+
+func (h *metricsAccessHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	userToken := h.getToken(req)
+	if userToken == "" {
+		writeError(w, http.StatusUnauthorized, rbac.ErrMissingToken)
+
+		return
+	}
+
+	var clusters []string
+	if h.clusters != nil {
+		clusters = h.clusters(req)
+	}
+
+	metricsAccess, err := h.reviewer.GetMetricsAccessWithContext(req.Context(), userToken, clusters...)
+	if err != nil {
+		klog.V(2).Infof("Failed to get the metrics access for request %s: %v", req.URL.Path, err)
+		writeError(w, statusForError(err), err)
+
+		return
+	}
+
+	ctx := context.WithValue(req.Context(), tokenKey, userToken)
+	ctx = context.WithValue(ctx, metricsAccessKey, metricsAccess)
+
+	h.next.ServeHTTP(w, req.WithContext(ctx))
+}
+
+// getToken returns the user's token from the request, or an empty string if it isn't set
+func (h *metricsAccessHandler) getToken(req *http.Request) string {
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		scheme, token, ok := strings.Cut(authorization, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	if token := req.Header.Get(ForwardedAccessTokenHeader); token != "" {
+		return token
+	}
+
+	if cookie, err := req.Cookie(h.tokenCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// statusForError returns the HTTP status code for the error returned by the Access Review API
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, rbac.ErrMissingToken), errors.Is(err, rbac.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, rbac.ErrForbidden), errors.Is(err, rbac.ErrIncompleteRules):
+		return http.StatusForbidden
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorResponse is the JSON body of the errors, in the same form as the errors of the Prometheus HTTP API
+type errorResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
+
+// writeError writes the JSON error response with the status code
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	errorType := "internal"
+
+	switch statusCode {
+	case http.StatusUnauthorized:
+		errorType = "unauthorized"
+	case http.StatusForbidden:
+		errorType = "forbidden"
+	case StatusClientClosedRequest:
+		errorType = "canceled"
+	case http.StatusGatewayTimeout:
+		errorType = "timeout"
+	}
+
+	message := err.Error()
+
+	switch statusCode {
+	case http.StatusInternalServerError:
+		// the details of internal errors are only logged
+		klog.Infof("Failed to authorize the request: %v", err)
+
+		message = "the user's access could not be reviewed"
+	case http.StatusGatewayTimeout:
+		klog.Infof("Timed out authorizing the request: %v", err)
+
+		message = "the user's access review timed out"
+	case StatusClientClosedRequest:
+		// the client is gone, it isn't a failure of the server
+		message = "the request was canceled"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(errorResponse{Status: "error", ErrorType: errorType, Error: message}); err != nil {
+		klog.Infof("Failed to write the error response: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/stolostron/rbac-api-utils/pkg/rbac"
+)
+
+// fakeReviewer is a MetricsAccessReviewer returning the same access and error to all the calls,
+// recording the token and clusters of the last call
+type fakeReviewer struct {
+	metricsAccess map[string][]string
+	err           error
+	userToken     string
+	clusters      []string
+}
+
+func (f *fakeReviewer) GetMetricsAccessWithContext(
+	ctx context.Context, userToken string, clusters ...string,
+) (map[string][]string, error) {
+	f.userToken = userToken
+	f.clusters = clusters
+
+	return f.metricsAccess, f.err
+}
+
+// contextRecorder is the next handler of the middleware, it records the values stored in the request context
+type contextRecorder struct {
+	called        bool
+	userToken     string
+	metricsAccess map[string][]string
+}
+
+func (c *contextRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.called = true
+	c.userToken, _ = TokenFromContext(req.Context())
+	c.metricsAccess, _ = MetricsAccessFromContext(req.Context())
+}
+
+func TestMetricsAccessToken(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		authorization  string
+		forwardedToken string
+		cookie         *http.Cookie
+		expectedToken  string
+	}{
+		{ // the Authorization header takes precedence
+			"Bearer header-token",
+			"forwarded-token",
+			&http.Cookie{Name: DefaultTokenCookie, Value: "cookie-token"},
+			"header-token",
+		},
+		{ // the bearer scheme is case-insensitive
+			"bearer header-token",
+			"",
+			nil,
+			"header-token",
+		},
+		{ // the X-Forwarded-Access-Token header is used next
+			"",
+			"forwarded-token",
+			&http.Cookie{Name: DefaultTokenCookie, Value: "cookie-token"},
+			"forwarded-token",
+		},
+		{ // authorization schemes other than bearer are ignored
+			"Basic dXNlcjpwYXNz",
+			"forwarded-token",
+			nil,
+			"forwarded-token",
+		},
+		{ // the cookie is used last
+			"",
+			"",
+			&http.Cookie{Name: DefaultTokenCookie, Value: "cookie-token"},
+			"cookie-token",
+		},
+	}
+
+	for _, test := range testcases {
+		reviewer := &fakeReviewer{metricsAccess: map[string][]string{}}
+		next := &contextRecorder{}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+
+		if test.forwardedToken != "" {
+			req.Header.Set(ForwardedAccessTokenHeader, test.forwardedToken)
+		}
+
+		if test.cookie != nil {
+			req.AddCookie(test.cookie)
+		}
+
+		MetricsAccess(reviewer)(next).ServeHTTP(httptest.NewRecorder(), req)
+
+		if reviewer.userToken != test.expectedToken {
+			t.Fatalf("expected reviewed token: %s got token: %s", test.expectedToken, reviewer.userToken)
+		}
+
+		if next.userToken != test.expectedToken {
+			t.Fatalf("expected token in context: %s got token: %s", test.expectedToken, next.userToken)
+		}
+	}
+}
+
+func TestMetricsAccessTokenCookie(t *testing.T) {
+	t.Parallel()
+
+	reviewer := &fakeReviewer{metricsAccess: map[string][]string{}}
+	next := &contextRecorder{}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultTokenCookie, Value: "default-token"})
+	req.AddCookie(&http.Cookie{Name: "my-cookie", Value: "cookie-token"})
+
+	MetricsAccess(reviewer, WithTokenCookie("my-cookie"))(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if next.userToken != "cookie-token" {
+		t.Fatalf("expected token in context: %s got token: %s", "cookie-token", next.userToken)
+	}
+}
+
+func TestMetricsAccessContext(t *testing.T) {
+	t.Parallel()
+
+	metricsAccess := map[string][]string{
+		"devcluster1": {"nsblue1", "nsblue2"},
+		"devcluster2": {"*"},
+	}
+	reviewer := &fakeReviewer{metricsAccess: metricsAccess}
+	next := &contextRecorder{}
+
+	clusters := func(req *http.Request) []string {
+		return req.URL.Query()["cluster"]
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query?cluster=devcluster1&cluster=devcluster2", nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+
+	recorder := httptest.NewRecorder()
+	MetricsAccess(reviewer, WithClusters(clusters))(next).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status: %d got status: %d", http.StatusOK, recorder.Code)
+	}
+
+	if expected := []string{"devcluster1", "devcluster2"}; !reflect.DeepEqual(reviewer.clusters, expected) {
+		t.Fatalf("expected reviewed clusters: %v got clusters: %v", expected, reviewer.clusters)
+	}
+
+	if next.userToken != "user-token" {
+		t.Fatalf("expected token in context: %s got token: %s", "user-token", next.userToken)
+	}
+
+	if !reflect.DeepEqual(next.metricsAccess, metricsAccess) {
+		t.Fatalf("expected metrics access in context: %v got metrics access: %v", metricsAccess, next.metricsAccess)
+	}
+
+	// the values are not set on requests that didn't go through the middleware
+	if _, ok := TokenFromContext(context.TODO()); ok {
+		t.Fatalf("expected no token in an empty context")
+	}
+
+	if _, ok := MetricsAccessFromContext(context.TODO()); ok {
+		t.Fatalf("expected no metrics access in an empty context")
+	}
+}
+
+func TestMetricsAccessErrors(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		userToken         string
+		err               error
+		expectedStatus    int
+		expectedErrorType string
+		expectedError     string
+	}{
+		{ // no token in the request
+			"",
+			nil,
+			http.StatusUnauthorized,
+			"unauthorized",
+			rbac.ErrMissingToken.Error(),
+		},
+		{
+			"user-token",
+			rbac.ErrMissingToken,
+			http.StatusUnauthorized,
+			"unauthorized",
+			rbac.ErrMissingToken.Error(),
+		},
+		{
+			"user-token",
+			&rbac.APIError{Kind: rbac.ErrUnauthenticated, Err: errors.New("invalid bearer token")},
+			http.StatusUnauthorized,
+			"unauthorized",
+			fmt.Sprintf("%s: invalid bearer token", rbac.ErrUnauthenticated),
+		},
+		{
+			"user-token",
+			&rbac.APIError{Kind: rbac.ErrForbidden, Err: errors.New("not allowed")},
+			http.StatusForbidden,
+			"forbidden",
+			fmt.Sprintf("%s: not allowed", rbac.ErrForbidden),
+		},
+		{
+			"user-token",
+			&rbac.IncompleteRulesError{Namespace: "nsblue1"},
+			http.StatusForbidden,
+			"forbidden",
+			(&rbac.IncompleteRulesError{Namespace: "nsblue1"}).Error(),
+		},
+		{ // the client disconnected
+			"user-token",
+			fmt.Errorf("failed to review: %w", &url.Error{Op: "Post", URL: "https://hub", Err: context.Canceled}),
+			StatusClientClosedRequest,
+			"canceled",
+			"the request was canceled",
+		},
+		{
+			"user-token",
+			fmt.Errorf("failed to review: %w", &url.Error{Op: "Post", URL: "https://hub", Err: context.DeadlineExceeded}),
+			http.StatusGatewayTimeout,
+			"timeout",
+			"the user's access review timed out",
+		},
+		{ // the details of other errors are not returned
+			"user-token",
+			errors.New("connection refused"),
+			http.StatusInternalServerError,
+			"internal",
+			"the user's access could not be reviewed",
+		},
+	}
+
+	for _, test := range testcases {
+		reviewer := &fakeReviewer{err: test.err}
+		next := &contextRecorder{}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+		if test.userToken != "" {
+			req.Header.Set("Authorization", "Bearer "+test.userToken)
+		}
+
+		recorder := httptest.NewRecorder()
+		MetricsAccess(reviewer)(next).ServeHTTP(recorder, req)
+
+		if next.called {
+			t.Fatalf("expected the next handler not to be called for err: %v", test.err)
+		}
+
+		if recorder.Code != test.expectedStatus {
+			t.Fatalf("expected status: %d got status: %d", test.expectedStatus, recorder.Code)
+		}
+
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("expected content type: application/json got content type: %s", contentType)
+		}
+
+		var body errorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to decode the error response %q: %v", recorder.Body.String(), err)
+		}
+
+		expectedBody := errorResponse{Status: "error", ErrorType: test.expectedErrorType, Error: test.expectedError}
+		if body != expectedBody {
+			t.Fatalf("expected error response: %+v got error response: %+v", expectedBody, body)
+		}
+	}
+}
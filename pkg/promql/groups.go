@@ -0,0 +1,100 @@
+package promql
+
+import (
+	"sort"
+	"strings"
+)
+
+// accessGroup is a set of managed clusters sharing the same allowed namespaces
+type accessGroup struct {
+	// clusters are the names of the managed clusters, nil means all the managed clusters
+	clusters []string
+	// namespaces are the names of the allowed namespaces, nil means all the namespaces
+	namespaces []string
+}
+
+// accessGroups groups the managed clusters of the map returned by GetMetricsAccess by their allowed namespaces,
+// so that each group can be enforced with a single pair of matchers. The namespaces allowed on all the managed
+// clusters, i.e. under the "*" key, form a group of their own and are dropped from the other groups.
+// It returns true if all the namespaces are allowed on all the managed clusters, and no group if the map
+// allows nothing.
+func accessGroups(metricsAccess map[string][]string) ([]accessGroup, bool) {
+	allClustersNamespaces := make(map[string]bool, len(metricsAccess["*"]))
+	for _, namespace := range metricsAccess["*"] {
+		allClustersNamespaces[namespace] = true
+	}
+
+	if allClustersNamespaces["*"] {
+		return nil, true
+	}
+
+	groups := []accessGroup{}
+
+	if len(allClustersNamespaces) > 0 {
+		groups = append(groups, accessGroup{namespaces: sortedKeys(allClustersNamespaces)})
+	}
+
+	// the clusters grouped by the key of their sorted namespaces, the key of all namespaces is "*"
+	clustersByNamespaces := map[string][]string{}
+	namespacesByKey := map[string][]string{}
+
+	for cluster, clusterNamespaces := range metricsAccess {
+		if cluster == "*" {
+			continue
+		}
+
+		namespaces := map[string]bool{}
+		allNamespaces := false
+
+		for _, namespace := range clusterNamespaces {
+			if namespace == "*" {
+				allNamespaces = true
+
+				break
+			}
+
+			if !allClustersNamespaces[namespace] {
+				namespaces[namespace] = true
+			}
+		}
+
+		key := "*"
+
+		switch {
+		case allNamespaces:
+			namespacesByKey[key] = nil
+		case len(namespaces) == 0:
+			continue
+		default:
+			sorted := sortedKeys(namespaces)
+			key = strings.Join(sorted, ",")
+			namespacesByKey[key] = sorted
+		}
+
+		clustersByNamespaces[key] = append(clustersByNamespaces[key], cluster)
+	}
+
+	clusterGroups := make([]accessGroup, 0, len(clustersByNamespaces))
+
+	for key, clusters := range clustersByNamespaces {
+		sort.Strings(clusters)
+		clusterGroups = append(clusterGroups, accessGroup{clusters: clusters, namespaces: namespacesByKey[key]})
+	}
+
+	sort.Slice(clusterGroups, func(i, j int) bool {
+		return clusterGroups[i].clusters[0] < clusterGroups[j].clusters[0]
+	})
+
+	return append(groups, clusterGroups...), false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
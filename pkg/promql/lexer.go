@@ -0,0 +1,258 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind is the kind of a token of a PromQL expression
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	// tokBraces is a whole set of label matchers, including the braces, e.g. {job="api"}
+	tokBraces
+	// tokBrackets is a whole range or subquery, including the brackets, e.g. [5m] or [1h:5m]
+	tokBrackets
+	tokLParen
+	tokRParen
+	tokOp
+)
+
+// token is a token of a PromQL expression. The whitespace and comments before the token are kept
+// so that the rewritten expression keeps the layout of the original one.
+type token struct {
+	kind tokenKind
+	text string
+	// pre is the whitespace and comments before the token
+	pre string
+}
+
+// parenGroup is a parenthesized list of items, i.e. *token or *parenGroup,
+// e.g. the arguments of a function call or a list of labels.
+type parenGroup struct {
+	open  *token
+	items []interface{}
+	close *token
+}
+
+// twoCharOps are the operators made of two characters
+var twoCharOps = []string{"==", "!=", ">=", "<=", "=~", "!~"}
+
+// lex splits the PromQL expression in tokens, it returns the whitespace and comments after the last token
+func lex(query string) ([]*token, string, error) {
+	var (
+		tokens []*token
+		i      int
+	)
+
+	for {
+		start := i
+		i = skipSpace(query, i)
+		pre := query[start:i]
+
+		if i == len(query) {
+			return tokens, pre, nil
+		}
+
+		tok := &token{pre: pre}
+		c := query[i]
+
+		switch {
+		case isIdentStart(c):
+			tok.kind = tokIdent
+			i = scanIdent(query, i)
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			tok.kind = tokNumber
+			i = scanNumber(query, i)
+		case c == '"' || c == '\'' || c == '`':
+			end, err := scanString(query, i)
+			if err != nil {
+				return nil, "", err
+			}
+
+			tok.kind = tokString
+			i = end
+		case c == '{':
+			end, err := scanUntil(query, i, '}')
+			if err != nil {
+				return nil, "", err
+			}
+
+			tok.kind = tokBraces
+			i = end
+		case c == '[':
+			end, err := scanUntil(query, i, ']')
+			if err != nil {
+				return nil, "", err
+			}
+
+			tok.kind = tokBrackets
+			i = end
+		case c == '(':
+			tok.kind = tokLParen
+			i++
+		case c == ')':
+			tok.kind = tokRParen
+			i++
+		case c == '}' || c == ']':
+			return nil, "", fmt.Errorf("%w: unexpected %q at position %d", ErrInvalidQuery, c, i)
+		default:
+			tok.kind = tokOp
+			i = scanOp(query, i)
+		}
+
+		tok.text = query[start+len(pre) : i]
+		tokens = append(tokens, tok)
+	}
+}
+
+// parse lexes the PromQL expression and nests the tokens in parenthesized groups.
+// It returns the top level items and the whitespace and comments after the last token.
+func parse(query string) ([]interface{}, string, error) {
+	tokens, trailing, err := lex(query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(tokens) == 0 {
+		return nil, "", fmt.Errorf("%w: empty query", ErrInvalidQuery)
+	}
+
+	// the stack of the groups being parsed, the first one holds the top level items
+	stack := []*parenGroup{{}}
+
+	for _, tok := range tokens {
+		current := stack[len(stack)-1]
+
+		switch tok.kind {
+		case tokLParen:
+			group := &parenGroup{open: tok}
+			current.items = append(current.items, group)
+			stack = append(stack, group)
+		case tokRParen:
+			if len(stack) == 1 {
+				return nil, "", fmt.Errorf("%w: unexpected \")\"", ErrInvalidQuery)
+			}
+
+			current.close = tok
+			stack = stack[:len(stack)-1]
+		default:
+			current.items = append(current.items, tok)
+		}
+	}
+
+	if len(stack) > 1 {
+		return nil, "", fmt.Errorf("%w: unclosed \"(\"", ErrInvalidQuery)
+	}
+
+	return stack[0].items, trailing, nil
+}
+
+// skipSpace returns the position of the first character that isn't whitespace or part of a comment
+func skipSpace(query string, i int) int {
+	for i < len(query) {
+		switch query[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '#':
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return len(query)
+			}
+
+			i += end
+		default:
+			return i
+		}
+	}
+
+	return i
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func scanIdent(query string, i int) int {
+	for i < len(query) && (isIdentStart(query[i]) || isDigit(query[i])) {
+		i++
+	}
+
+	return i
+}
+
+// scanNumber returns the end of a number or a duration, e.g. 1.5, 1e-3, 0x1f or 1h30m
+func scanNumber(query string, i int) int {
+	start := i
+
+	for i < len(query) {
+		c := query[i]
+
+		switch {
+		case isDigit(c) || c == '.' || c == '_' || isIdentStart(c) && c != ':':
+			i++
+		case (c == '+' || c == '-') && (query[i-1] == 'e' || query[i-1] == 'E') &&
+			!strings.HasPrefix(strings.ToLower(query[start:]), "0x"):
+			i++
+		default:
+			return i
+		}
+	}
+
+	return i
+}
+
+// scanString returns the end of the string starting at i, with escape sequences in all but backtick strings
+func scanString(query string, i int) (int, error) {
+	quote := query[i]
+
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if quote != '`' {
+				j++
+			}
+		case quote:
+			return j + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: unterminated string at position %d", ErrInvalidQuery, i)
+}
+
+// scanUntil returns the end of the block starting at i and ending with the closing character,
+// the strings in the block may contain the closing character.
+func scanUntil(query string, i int, closing byte) (int, error) {
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '"', '\'', '`':
+			end, err := scanString(query, j)
+			if err != nil {
+				return 0, err
+			}
+
+			j = end - 1
+		case closing:
+			return j + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: unclosed %q at position %d", ErrInvalidQuery, query[i], i)
+}
+
+func scanOp(query string, i int) int {
+	for _, op := range twoCharOps {
+		if strings.HasPrefix(query[i:], op) {
+			return i + len(op)
+		}
+	}
+
+	return i + 1
+}
@@ -0,0 +1,119 @@
+package promql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchType is the operator of a label matcher
+type MatchType string
+
+const (
+	// MatchEqual selects the series with a label equal to the value
+	MatchEqual MatchType = "="
+	// MatchRegexp selects the series with a label matching the anchored regexp value
+	MatchRegexp MatchType = "=~"
+)
+
+// Matcher is a Prometheus label matcher, e.g. namespace=~"nsblue1|nsblue2"
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+// String returns the matcher in PromQL syntax, with the value quoted
+func (m Matcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// Selector is a set of label matchers that must all match, i.e. a vector selector without a metric name
+type Selector []Matcher
+
+// String returns the selector in PromQL syntax, e.g. {cluster="devcluster1", namespace="nsblue1"}
+func (s Selector) String() string {
+	return "{" + s.matchers() + "}"
+}
+
+// matchers returns the comma-separated matchers of the selector, without the braces
+func (s Selector) matchers() string {
+	matchers := make([]string, len(s))
+	for i, matcher := range s {
+		matchers[i] = matcher.String()
+	}
+
+	return strings.Join(matchers, ", ")
+}
+
+// LabelMatchers converts the user's access to metrics into the minimal set of label matchers selecting the series
+// of the allowed (cluster, namespace) pairs: a series is allowed if it matches any of the returned selectors.
+// The managed clusters sharing the same allowed namespaces are grouped in a single selector with one regexp per
+// label, and the names are escaped in the regexps. A single empty selector is returned when all the namespaces
+// are allowed on all the managed clusters, and no selector when no access is allowed.
+//
+// - metricsAccess is the map of managed clusters and namespaces returned by GetMetricsAccess
+//
+// - opts are optional settings for the label names, i.e. WithClusterLabel and WithNamespaceLabel
+func LabelMatchers(metricsAccess map[string][]string, opts ...Option) []Selector {
+	return NewEnforcer(metricsAccess, opts...).LabelMatchers()
+}
+
+// LabelMatchers returns the label matchers enforced by the Enforcer, see LabelMatchers.
+func (e *Enforcer) LabelMatchers() []Selector {
+	if e.unrestricted {
+		return []Selector{{}}
+	}
+
+	selectors := make([]Selector, len(e.groups))
+	for i := range e.groups {
+		selectors[i] = e.groupSelector(i)
+	}
+
+	return selectors
+}
+
+// Allowed reports whether the user's access to metrics, as returned by GetMetricsAccess, allows the series of
+// the namespace on the managed cluster. An empty namespace, i.e. a series without a namespace label,
+// is only allowed when all the namespaces of the managed cluster are allowed.
+func Allowed(metricsAccess map[string][]string, cluster string, namespace string) bool {
+	for _, key := range []string{"*", cluster} {
+		for _, allowed := range metricsAccess[key] {
+			if allowed == "*" || (allowed == namespace && namespace != "") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// groupSelector returns the label matchers enforcing the access of the group
+func (e *Enforcer) groupSelector(group int) Selector {
+	selector := make(Selector, 0, 2)
+
+	if clusters := e.groups[group].clusters; clusters != nil {
+		selector = append(selector, labelMatcher(e.clusterLabel, clusters))
+	}
+
+	if namespaces := e.groups[group].namespaces; namespaces != nil {
+		selector = append(selector, labelMatcher(e.namespaceLabel, namespaces))
+	}
+
+	return selector
+}
+
+// labelMatcher returns the matcher of the label for the values, an equality matcher for a single value
+// or a regexp matcher with the escaped values otherwise
+func labelMatcher(label string, values []string) Matcher {
+	if len(values) == 1 {
+		return Matcher{Name: label, Type: MatchEqual, Value: values[0]}
+	}
+
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = regexp.QuoteMeta(value)
+	}
+
+	return Matcher{Name: label, Type: MatchRegexp, Value: strings.Join(escaped, "|")}
+}
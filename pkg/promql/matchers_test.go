@@ -0,0 +1,120 @@
+package promql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelMatchers(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		metricsAccess map[string][]string
+		expected      []string
+	}{
+		{
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			[]string{`{cluster="devcluster1", namespace="nsblue1"}`},
+		},
+		{ // clusters sharing the same namespaces are grouped
+			map[string][]string{
+				"devcluster1": {"nsblue2", "nsblue1"},
+				"devcluster2": {"nsred1"},
+				"devcluster3": {"nsblue1", "nsblue2"},
+			},
+			[]string{
+				`{cluster=~"devcluster1|devcluster3", namespace=~"nsblue1|nsblue2"}`,
+				`{cluster="devcluster2", namespace="nsred1"}`,
+			},
+		},
+		{ // the namespaces allowed on all the clusters are not repeated
+			map[string][]string{
+				"*":           {"kube-system"},
+				"devcluster1": {"kube-system", "nsblue1"},
+				"devcluster2": {"kube-system"},
+			},
+			[]string{`{namespace="kube-system"}`, `{cluster="devcluster1", namespace="nsblue1"}`},
+		},
+		{ // all the namespaces of a cluster
+			map[string][]string{"devcluster1": {"nsblue1", "*"}, "devcluster2": {"*"}},
+			[]string{`{cluster=~"devcluster1|devcluster2"}`},
+		},
+		{ // names are escaped
+			map[string][]string{"dev.cluster1": {"ns+1", "ns.2"}, "dev.cluster2": {"ns+1", "ns.2"}},
+			[]string{`{cluster=~"dev\\.cluster1|dev\\.cluster2", namespace=~"ns\\+1|ns\\.2"}`},
+		},
+		{ // everything is allowed
+			map[string][]string{"*": {"*"}},
+			[]string{`{}`},
+		},
+		{ // nothing is allowed
+			map[string][]string{"devcluster1": {}},
+			[]string{},
+		},
+	}
+
+	for _, test := range testcases {
+		selectors := LabelMatchers(test.metricsAccess)
+
+		result := make([]string, len(selectors))
+		for i, selector := range selectors {
+			result[i] = selector.String()
+		}
+
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Fatalf("expected selectors: %v got selectors: %v", test.expected, result)
+		}
+	}
+
+	// the label names can be set
+	selectors := LabelMatchers(map[string][]string{"devcluster1": {"nsblue1", "nsblue2"}},
+		WithClusterLabel("managed_cluster"), WithNamespaceLabel("kubernetes_namespace"))
+	expected := []Selector{{
+		{Name: "managed_cluster", Type: MatchEqual, Value: "devcluster1"},
+		{Name: "kubernetes_namespace", Type: MatchRegexp, Value: "nsblue1|nsblue2"},
+	}}
+
+	if !reflect.DeepEqual(selectors, expected) {
+		t.Fatalf("expected selectors: %v got selectors: %v", expected, selectors)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	t.Parallel()
+
+	metricsAccess := map[string][]string{
+		"*":           {"kube-system"},
+		"devcluster1": {"nsblue1", "nsblue2"},
+		"devcluster2": {"*"},
+	}
+
+	testcases := []struct {
+		cluster   string
+		namespace string
+		expected  bool
+	}{
+		{"devcluster1", "nsblue1", true},
+		{"devcluster1", "nsred1", false},
+		{"devcluster1", "kube-system", true},
+		{"devcluster3", "kube-system", true},
+		{"devcluster3", "nsblue1", false},
+		{"devcluster2", "nsred1", true},
+		{"devcluster1", "", false}, // series without namespace need all the namespaces
+		{"devcluster2", "", true},
+	}
+
+	for _, test := range testcases {
+		if result := Allowed(metricsAccess, test.cluster, test.namespace); result != test.expected {
+			t.Fatalf("expected allowed: %t got allowed: %t for %s/%s", test.expected, result, test.cluster,
+				test.namespace)
+		}
+	}
+
+	if Allowed(map[string][]string{}, "devcluster1", "nsblue1") {
+		t.Fatalf("expected no access to be allowed with an empty access")
+	}
+
+	if !Allowed(map[string][]string{"*": {"*"}}, "devcluster1", "") {
+		t.Fatalf("expected all access to be allowed with the \"*\" access")
+	}
+}
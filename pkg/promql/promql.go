@@ -0,0 +1,452 @@
+// Package promql rewrites PromQL queries to enforce the user's access to metrics returned by GetMetricsAccess,
+// i.e. the managed clusters and namespaces the user is allowed to view metrics for.
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultClusterLabel is the label holding the name of the managed cluster of the series
+	DefaultClusterLabel = "cluster"
+	// DefaultNamespaceLabel is the label holding the namespace of the series
+	DefaultNamespaceLabel = "namespace"
+)
+
+var (
+	// ErrInvalidQuery is returned when the query can't be parsed, e.g. when a parenthesis or a string isn't closed.
+	ErrInvalidQuery = errors.New("invalid PromQL query")
+	// ErrUnsupportedQuery is returned when the access can't be enforced on a valid query, i.e. when a range vector
+	// selector isn't the argument of a function and the allowed namespaces differ between the managed clusters.
+	ErrUnsupportedQuery = errors.New("the access can't be enforced on the PromQL query")
+)
+
+// labelListKeywords are the keywords followed by a list of labels
+var labelListKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+}
+
+// keywords are the other keywords and the binary operators spelled as words
+var keywords = map[string]bool{
+	"and": true, "or": true, "unless": true, "bool": true, "offset": true, "atan2": true, "inf": true, "nan": true,
+}
+
+// Enforcer rewrites PromQL queries so that they only select the series of the (cluster, namespace) pairs
+// allowed by the result of GetMetricsAccess. It must be created with NewEnforcer.
+//
+// Matchers on the cluster and namespace labels are added to every vector selector of the query. When all the
+// managed clusters are allowed the same namespaces, each selector gets one matcher per label, e.g.
+// up{cluster=~"devcluster1|devcluster2", namespace=~"nsblue1|nsblue2"}. Otherwise, each selector is expanded into
+// the union of one selector per group of managed clusters sharing the same namespaces, e.g.
+// (up{cluster="devcluster1", namespace="nsblue1"} or up{cluster="devcluster2", namespace="nsred1"}), and
+// the function calls taking a range vector selector are expanded in the same way.
+//
+// The series without a namespace label are only selected when all the namespaces of their managed cluster are
+// allowed. When no access is allowed, the selectors select no series.
+type Enforcer struct {
+	clusterLabel   string
+	namespaceLabel string
+	// groups are the groups of managed clusters sharing the same allowed namespaces
+	groups []accessGroup
+	// unrestricted is true when all the namespaces are allowed on all the managed clusters
+	unrestricted bool
+}
+
+// Option configures optional behavior of an Enforcer, it is passed to NewEnforcer.
+type Option func(*Enforcer)
+
+// WithClusterLabel sets the label holding the name of the managed cluster, DefaultClusterLabel is used if empty.
+func WithClusterLabel(label string) Option {
+	return func(e *Enforcer) {
+		if label != "" {
+			e.clusterLabel = label
+		}
+	}
+}
+
+// WithNamespaceLabel sets the label holding the namespace, DefaultNamespaceLabel is used if empty.
+func WithNamespaceLabel(label string) Option {
+	return func(e *Enforcer) {
+		if label != "" {
+			e.namespaceLabel = label
+		}
+	}
+}
+
+// NewEnforcer creates an Enforcer for the user's access to metrics.
+//
+// - metricsAccess is the map of managed clusters and namespaces returned by GetMetricsAccess,
+// the "*" managed cluster and namespace allow all managed clusters and namespaces respectively
+//
+// - opts are optional settings for the Enforcer, e.g. WithClusterLabel
+func NewEnforcer(metricsAccess map[string][]string, opts ...Option) *Enforcer {
+	enforcer := &Enforcer{
+		clusterLabel:   DefaultClusterLabel,
+		namespaceLabel: DefaultNamespaceLabel,
+	}
+
+	enforcer.groups, enforcer.unrestricted = accessGroups(metricsAccess)
+
+	for _, opt := range opts {
+		opt(enforcer)
+	}
+
+	return enforcer
+}
+
+// Rewrite is a shortcut to rewrite a single query with a new Enforcer, see Enforcer.Rewrite.
+func Rewrite(query string, metricsAccess map[string][]string, opts ...Option) (string, error) {
+	return NewEnforcer(metricsAccess, opts...).Rewrite(query)
+}
+
+// Rewrite returns the query with the access enforced on all of its vector selectors. The layout of the query,
+// i.e. its whitespace and comments, is kept. An ErrInvalidQuery error is returned if the query can't be parsed
+// and an ErrUnsupportedQuery error if the access can't be enforced on it.
+func (e *Enforcer) Rewrite(query string) (string, error) {
+	items, trailing, err := parse(query)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+
+	if err := e.writeItems(&builder, items, -1); err != nil {
+		return "", err
+	}
+
+	builder.WriteString(trailing)
+
+	return builder.String(), nil
+}
+
+// writeItems writes the items of the query with the access enforced on their vector selectors.
+// The range vector selectors get the matchers of the given group, when it isn't negative.
+func (e *Enforcer) writeItems(builder *strings.Builder, items []interface{}, group int) error {
+	for i := 0; i < len(items); {
+		switch item := items[i].(type) {
+		case *parenGroup:
+			if err := e.writeGroup(builder, item, -1); err != nil {
+				return err
+			}
+
+			i++
+		case *token:
+			next, err := e.writeToken(builder, items, i, group)
+			if err != nil {
+				return err
+			}
+
+			i = next
+		}
+	}
+
+	return nil
+}
+
+// writeToken writes the token at position i, and the following items it goes with,
+// and returns the position of the next item to write
+func (e *Enforcer) writeToken(builder *strings.Builder, items []interface{}, i int, group int) (int, error) {
+	tok := items[i].(*token)
+
+	switch tok.kind {
+	case tokBraces:
+		return e.writeSelector(builder, items, i, group)
+	case tokIdent:
+	default:
+		writeRaw(builder, tok)
+
+		return i + 1, nil
+	}
+
+	word := strings.ToLower(tok.text)
+	next := itemAt(items, i+1)
+	args, isCall := next.(*parenGroup)
+
+	switch {
+	case labelListKeywords[word]:
+		// e.g. "by (job)", the labels are written as is
+		writeRaw(builder, tok)
+
+		if isCall {
+			writeRaw(builder, args)
+
+			return i + 2, nil
+		}
+
+		return i + 1, nil
+	case keywords[word]:
+		writeRaw(builder, tok)
+
+		return i + 1, nil
+	case isCall:
+		return i + 2, e.writeCall(builder, tok, args)
+	case isIdentToken(next, "by", "without"):
+		// the aggregation operator of e.g. "sum by (job) (up)"
+		writeRaw(builder, tok)
+
+		return i + 1, nil
+	default:
+		return e.writeSelector(builder, items, i, group)
+	}
+}
+
+// writeCall writes a function call. When the call takes a range vector selector and the managed clusters have
+// different allowed namespaces, it is expanded into the union of one call per group of managed clusters,
+// as range vectors can't be joined with "or".
+func (e *Enforcer) writeCall(builder *strings.Builder, name *token, args *parenGroup) error {
+	if !e.expands() || !hasRangeSelector(args.items) {
+		writeRaw(builder, name)
+
+		return e.writeGroup(builder, args, -1)
+	}
+
+	builder.WriteString(name.pre)
+	builder.WriteString("(")
+
+	for group := range e.groups {
+		if group > 0 {
+			builder.WriteString(" or ")
+		}
+
+		builder.WriteString(name.text)
+
+		if err := e.writeGroup(builder, args, group); err != nil {
+			return err
+		}
+	}
+
+	builder.WriteString(")")
+
+	return nil
+}
+
+// writeGroup writes the parenthesized group, the range vector selectors in it get the matchers of the given group
+func (e *Enforcer) writeGroup(builder *strings.Builder, parens *parenGroup, group int) error {
+	writeRaw(builder, parens.open)
+
+	if err := e.writeItems(builder, parens.items, group); err != nil {
+		return err
+	}
+
+	writeRaw(builder, parens.close)
+
+	return nil
+}
+
+// writeSelector writes the vector selector starting at position i, i.e. a metric name and/or label matchers,
+// with the access enforced, and returns the position of the next item to write
+func (e *Enforcer) writeSelector(builder *strings.Builder, items []interface{}, i int, group int) (int, error) {
+	start := i
+
+	var name, matchers *token
+
+	if tok := items[i].(*token); tok.kind == tokIdent {
+		name = tok
+		i++
+	}
+
+	if tok, ok := itemAt(items, i).(*token); ok && tok.kind == tokBraces {
+		matchers = tok
+		i++
+	}
+
+	switch {
+	case e.unrestricted:
+		writeRaw(builder, items[start:i]...)
+
+		return i, nil
+	case !e.expands():
+		builder.WriteString(selectorWithMatchers(name, matchers, e.groupMatchers(0), true))
+
+		return i, nil
+	}
+
+	if brackets, ok := itemAt(items, i).(*token); ok && brackets.kind == tokBrackets &&
+		!strings.Contains(brackets.text, ":") {
+		// range vector selectors are expanded by the function call they are the argument of
+		if group < 0 {
+			return 0, fmt.Errorf("%w: the range vector selector %s%s isn't the argument of a function",
+				ErrUnsupportedQuery, selectorWithMatchers(name, matchers, "", false), brackets.text)
+		}
+
+		builder.WriteString(selectorWithMatchers(name, matchers, e.groupMatchers(group), true))
+
+		return i, nil
+	}
+
+	// the offset and @ modifiers of an instant vector selector are part of each selector of the union
+	end := skipModifiers(items, i)
+
+	var modifiers strings.Builder
+	writeRaw(&modifiers, items[i:end]...)
+
+	builder.WriteString(items[start].(*token).pre)
+	builder.WriteString("(")
+
+	for group := range e.groups {
+		if group > 0 {
+			builder.WriteString(" or ")
+		}
+
+		builder.WriteString(selectorWithMatchers(name, matchers, e.groupMatchers(group), false))
+		builder.WriteString(modifiers.String())
+	}
+
+	builder.WriteString(")")
+
+	return end, nil
+}
+
+// expands reports whether the selectors are expanded into a union of selectors, one per group
+func (e *Enforcer) expands() bool {
+	return !e.unrestricted && len(e.groups) > 1
+}
+
+// groupMatchers returns the label matchers enforcing the access of the group, or the matchers selecting
+// no series if no access is allowed
+func (e *Enforcer) groupMatchers(group int) string {
+	if len(e.groups) == 0 {
+		// no series has both an empty and a non-empty cluster label
+		return Selector{
+			{Name: e.clusterLabel, Type: MatchEqual, Value: ""},
+			{Name: e.clusterLabel, Type: MatchRegexp, Value: ".+"},
+		}.matchers()
+	}
+
+	return e.groupSelector(group).matchers()
+}
+
+// selectorWithMatchers returns the text of the vector selector with the extra label matchers added,
+// with the whitespace before the selector if withPre is set
+func selectorWithMatchers(name *token, matchers *token, extraMatchers string, withPre bool) string {
+	var builder strings.Builder
+
+	if name != nil {
+		if withPre {
+			builder.WriteString(name.pre)
+		}
+
+		builder.WriteString(name.text)
+	}
+
+	if matchers == nil {
+		builder.WriteString("{" + extraMatchers + "}")
+
+		return builder.String()
+	}
+
+	if withPre || name != nil {
+		builder.WriteString(matchers.pre)
+	}
+
+	inner := strings.TrimRight(matchers.text[1:len(matchers.text)-1], " \t\r\n")
+
+	switch {
+	case extraMatchers == "":
+		builder.WriteString(matchers.text)
+	case strings.TrimSpace(inner) == "":
+		builder.WriteString("{" + extraMatchers + "}")
+	case strings.HasSuffix(inner, ","):
+		builder.WriteString("{" + inner + " " + extraMatchers + "}")
+	default:
+		builder.WriteString("{" + inner + ", " + extraMatchers + "}")
+	}
+
+	return builder.String()
+}
+
+// hasRangeSelector reports whether the items include a range vector selector, i.e. a range without a step
+func hasRangeSelector(items []interface{}) bool {
+	for _, item := range items {
+		if tok, ok := item.(*token); ok && tok.kind == tokBrackets && !strings.Contains(tok.text, ":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipModifiers returns the position of the first item after the offset and @ modifiers starting at position i
+func skipModifiers(items []interface{}, i int) int {
+	for {
+		switch {
+		case isIdentToken(itemAt(items, i), "offset"):
+			i++
+		case isOpToken(itemAt(items, i), "@"):
+			i++
+
+			// the @ start() and @ end() modifiers
+			if isIdentToken(itemAt(items, i), "start", "end") {
+				if _, ok := itemAt(items, i+1).(*parenGroup); ok {
+					i++
+				}
+			}
+		default:
+			return i
+		}
+
+		if isOpToken(itemAt(items, i), "-", "+") {
+			i++
+		}
+
+		i++
+	}
+}
+
+// itemAt returns the item at position i, or nil if there is none
+func itemAt(items []interface{}, i int) interface{} {
+	if i < len(items) {
+		return items[i]
+	}
+
+	return nil
+}
+
+// isIdentToken reports whether the item is an identifier matching one of the words, regardless of case
+func isIdentToken(item interface{}, words ...string) bool {
+	tok, ok := item.(*token)
+	if !ok || tok.kind != tokIdent {
+		return false
+	}
+
+	for _, word := range words {
+		if strings.EqualFold(tok.text, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isOpToken reports whether the item is one of the operators
+func isOpToken(item interface{}, ops ...string) bool {
+	tok, ok := item.(*token)
+	if !ok || tok.kind != tokOp {
+		return false
+	}
+
+	for _, op := range ops {
+		if tok.text == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeRaw writes the items as they are in the original query
+func writeRaw(builder *strings.Builder, items ...interface{}) {
+	for _, item := range items {
+		switch item := item.(type) {
+		case *token:
+			builder.WriteString(item.pre)
+			builder.WriteString(item.text)
+		case *parenGroup:
+			writeRaw(builder, item.open)
+			writeRaw(builder, item.items...)
+			writeRaw(builder, item.close)
+		}
+	}
+}
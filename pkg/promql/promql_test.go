@@ -0,0 +1,260 @@
+package promql
+
+import (
+	"errors"
+	"testing"
+)
+
+var (
+	// the same namespaces are allowed on both managed clusters
+	sameNamespacesAccess = map[string][]string{
+		"devcluster1": {"nsblue1", "nsblue2"},
+		"devcluster2": {"nsblue2", "nsblue1"},
+	}
+	// different namespaces are allowed on the managed clusters
+	differentNamespacesAccess = map[string][]string{
+		"devcluster1": {"nsblue1"},
+		"devcluster2": {"nsred1", "nsred2"},
+	}
+)
+
+func TestRewrite(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		query         string
+		metricsAccess map[string][]string
+		expected      string
+	}{
+		{ // a single cluster and namespace get equality matchers
+			`up`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`up{cluster="devcluster1", namespace="nsblue1"}`,
+		},
+		{
+			`up`,
+			sameNamespacesAccess,
+			`up{cluster=~"devcluster1|devcluster2", namespace=~"nsblue1|nsblue2"}`,
+		},
+		{ // existing matchers are kept
+			`up{job="api"}`,
+			sameNamespacesAccess,
+			`up{job="api", cluster=~"devcluster1|devcluster2", namespace=~"nsblue1|nsblue2"}`,
+		},
+		{ // a trailing comma is allowed
+			`up{job="api",}`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`up{job="api", cluster="devcluster1", namespace="nsblue1"}`,
+		},
+		{ // existing cluster and namespace matchers are kept, so both must match
+			`up{namespace="nsred1"}`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`up{namespace="nsred1", cluster="devcluster1", namespace="nsblue1"}`,
+		},
+		{ // selectors without a metric name
+			`{__name__=~"http_.*", job="api"}`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`{__name__=~"http_.*", job="api", cluster="devcluster1", namespace="nsblue1"}`,
+		},
+		{ // braces in strings
+			`up{job="}"}`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`up{job="}", cluster="devcluster1", namespace="nsblue1"}`,
+		},
+		{ // range vector selectors, offset and @ modifiers
+			`rate(http_requests_total{job="api"}[5m] offset 1h @ 1609746000)`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`rate(http_requests_total{job="api", cluster="devcluster1", namespace="nsblue1"}[5m] offset 1h @ 1609746000)`,
+		},
+		{ // aggregations, their labels and binary operators
+			`sum by (namespace) (rate(http_requests_total[5m])) / on(namespace) group_left(team) team_info`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`sum by (namespace) (rate(http_requests_total{cluster="devcluster1", namespace="nsblue1"}[5m])) / ` +
+				`on(namespace) group_left(team) team_info{cluster="devcluster1", namespace="nsblue1"}`,
+		},
+		{ // keywords are case-insensitive and numbers, strings and functions are kept
+			`SUM(up) BY (job) > BOOL 0.5 AND label_replace(up, "dst", "$1", "src", "(.*)") or vector(Inf)`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`SUM(up{cluster="devcluster1", namespace="nsblue1"}) BY (job) > BOOL 0.5 AND ` +
+				`label_replace(up{cluster="devcluster1", namespace="nsblue1"}, "dst", "$1", "src", "(.*)") or vector(Inf)`,
+		},
+		{ // subqueries
+			`max_over_time(rate(up[5m])[1h:1m])`,
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			`max_over_time(rate(up{cluster="devcluster1", namespace="nsblue1"}[5m])[1h:1m])`,
+		},
+		{ // the layout and comments are kept
+			"sum(\n  up # the targets\n) by (job)\n",
+			map[string][]string{"devcluster1": {"nsblue1"}},
+			"sum(\n  up{cluster=\"devcluster1\", namespace=\"nsblue1\"} # the targets\n) by (job)\n",
+		},
+		{ // all the namespaces on a cluster
+			`up`,
+			map[string][]string{"devcluster1": {"*"}, "devcluster2": {"nsblue1", "*"}},
+			`up{cluster=~"devcluster1|devcluster2"}`,
+		},
+		{ // namespaces on all the clusters
+			`up`,
+			map[string][]string{"*": {"kube-system", "openshift-monitoring"}},
+			`up{namespace=~"kube-system|openshift-monitoring"}`,
+		},
+		{ // all the namespaces on all the clusters
+			`sum(rate(up[5m])) by (job)`,
+			map[string][]string{"*": {"*"}, "devcluster1": {"nsblue1"}},
+			`sum(rate(up[5m])) by (job)`,
+		},
+		{ // no access selects nothing
+			`up`,
+			map[string][]string{},
+			`up{cluster="", cluster=~".+"}`,
+		},
+		{ // clusters without namespaces allow nothing
+			`rate(up[5m])`,
+			map[string][]string{"devcluster1": {}},
+			`rate(up{cluster="", cluster=~".+"}[5m])`,
+		},
+		{ // names are escaped in regexps
+			`up`,
+			map[string][]string{"dev.cluster1": {"ns1"}, "dev.cluster2": {"ns1"}},
+			`up{cluster=~"dev\\.cluster1|dev\\.cluster2", namespace="ns1"}`,
+		},
+		{ // different namespaces expand instant vector selectors into a union
+			`up{job="api"}`,
+			differentNamespacesAccess,
+			`(up{job="api", cluster="devcluster1", namespace="nsblue1"} or ` +
+				`up{job="api", cluster="devcluster2", namespace=~"nsred1|nsred2"})`,
+		},
+		{ // modifiers are part of each selector
+			`sum(up offset 5m) by (job)`,
+			differentNamespacesAccess,
+			`sum((up{cluster="devcluster1", namespace="nsblue1"} offset 5m or ` +
+				`up{cluster="devcluster2", namespace=~"nsred1|nsred2"} offset 5m)) by (job)`,
+		},
+		{ // @ start() modifiers
+			`up @ start()`,
+			differentNamespacesAccess,
+			`(up{cluster="devcluster1", namespace="nsblue1"} @ start() or ` +
+				`up{cluster="devcluster2", namespace=~"nsred1|nsred2"} @ start())`,
+		},
+		{ // function calls taking range vector selectors are expanded
+			`sum(rate(http_requests_total[5m]))`,
+			differentNamespacesAccess,
+			`sum((rate(http_requests_total{cluster="devcluster1", namespace="nsblue1"}[5m]) or ` +
+				`rate(http_requests_total{cluster="devcluster2", namespace=~"nsred1|nsred2"}[5m])))`,
+		},
+		{
+			`quantile_over_time(0.9, latency[10m])`,
+			differentNamespacesAccess,
+			`(quantile_over_time(0.9, latency{cluster="devcluster1", namespace="nsblue1"}[10m]) or ` +
+				`quantile_over_time(0.9, latency{cluster="devcluster2", namespace=~"nsred1|nsred2"}[10m]))`,
+		},
+		{ // subqueries of instant vector selectors
+			`max_over_time(up[1h:5m])`,
+			differentNamespacesAccess,
+			`max_over_time((up{cluster="devcluster1", namespace="nsblue1"} or ` +
+				`up{cluster="devcluster2", namespace=~"nsred1|nsred2"})[1h:5m])`,
+		},
+		{ // the namespaces on all the clusters form a group of their own
+			`up`,
+			map[string][]string{"*": {"kube-system"}, "devcluster1": {"kube-system", "nsblue1"}},
+			`(up{namespace="kube-system"} or up{cluster="devcluster1", namespace="nsblue1"})`,
+		},
+		{ // clusters sharing namespaces are grouped
+			`up`,
+			map[string][]string{"devcluster1": {"nsblue1"}, "devcluster2": {"*"}, "devcluster3": {"nsblue1"}},
+			`(up{cluster=~"devcluster1|devcluster3", namespace="nsblue1"} or up{cluster="devcluster2"})`,
+		},
+	}
+
+	for _, test := range testcases {
+		result, err := Rewrite(test.query, test.metricsAccess)
+		if err != nil {
+			t.Fatalf("failed to rewrite query %q: %v", test.query, err)
+		}
+
+		if result != test.expected {
+			t.Fatalf("expected query: %s got query: %s", test.expected, result)
+		}
+	}
+}
+
+func TestRewriteLabels(t *testing.T) {
+	t.Parallel()
+
+	enforcer := NewEnforcer(
+		map[string][]string{"devcluster1": {"nsblue1"}},
+		WithClusterLabel("managed_cluster"),
+		WithNamespaceLabel("kubernetes_namespace"),
+	)
+
+	testcases := []struct {
+		query    string
+		expected string
+	}{
+		{`up`, `up{managed_cluster="devcluster1", kubernetes_namespace="nsblue1"}`},
+		{`rate(up[5m])`, `rate(up{managed_cluster="devcluster1", kubernetes_namespace="nsblue1"}[5m])`},
+	}
+
+	for _, test := range testcases {
+		result, err := enforcer.Rewrite(test.query)
+		if err != nil {
+			t.Fatalf("failed to rewrite query %q: %v", test.query, err)
+		}
+
+		if result != test.expected {
+			t.Fatalf("expected query: %s got query: %s", test.expected, result)
+		}
+	}
+
+	// empty labels keep the default labels
+	result, err := Rewrite(`up`, map[string][]string{"devcluster1": {"nsblue1"}}, WithClusterLabel(""),
+		WithNamespaceLabel(""))
+	if err != nil {
+		t.Fatalf("failed to rewrite query: %v", err)
+	}
+
+	if expected := `up{cluster="devcluster1", namespace="nsblue1"}`; result != expected {
+		t.Fatalf("expected query: %s got query: %s", expected, result)
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		query         string
+		metricsAccess map[string][]string
+		expectedErr   error
+	}{
+		{``, sameNamespacesAccess, ErrInvalidQuery},
+		{`  # only a comment`, sameNamespacesAccess, ErrInvalidQuery},
+		{`sum(up`, sameNamespacesAccess, ErrInvalidQuery},
+		{`sum(up))`, sameNamespacesAccess, ErrInvalidQuery},
+		{`up{job="api"`, sameNamespacesAccess, ErrInvalidQuery},
+		{`up{job="api}`, sameNamespacesAccess, ErrInvalidQuery},
+		{`rate(up[5m)`, sameNamespacesAccess, ErrInvalidQuery},
+		{`up}`, sameNamespacesAccess, ErrInvalidQuery},
+		{ // range vectors can't be joined with "or"
+			`up[5m]`,
+			differentNamespacesAccess,
+			ErrUnsupportedQuery,
+		},
+	}
+
+	for _, test := range testcases {
+		_, err := Rewrite(test.query, test.metricsAccess)
+		if !errors.Is(err, test.expectedErr) {
+			t.Fatalf("expected err: %s got err: %v for query %q", test.expectedErr, err, test.query)
+		}
+	}
+
+	// top level range vectors are supported when the access doesn't need a union
+	result, err := Rewrite(`up[5m]`, sameNamespacesAccess)
+	if err != nil {
+		t.Fatalf("failed to rewrite query: %v", err)
+	}
+
+	if expected := `up{cluster=~"devcluster1|devcluster2", namespace=~"nsblue1|nsblue2"}[5m]`; result != expected {
+		t.Fatalf("expected query: %s got query: %s", expected, result)
+	}
+}
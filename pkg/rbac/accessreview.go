@@ -0,0 +1,103 @@
+package rbac
+
+import (
+	"context"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// AccessDecision is the result of checking the user's access for a set of resource attributes.
+type AccessDecision struct {
+	// ResourceAttributes are the attributes the access was checked for
+	ResourceAttributes authorizationv1.ResourceAttributes
+	// Allowed is true if the action described by the attributes is allowed for the user
+	Allowed bool
+	// Reason is the reason for the decision, as returned by the authorizer. It may be empty.
+	Reason string
+	// Err is set if the access could not be checked, in which case Allowed is false
+	Err error
+}
+
+// CheckAccess checks if the user is allowed to perform the action described by the given resource attributes,
+// by making a SelfSubjectAccessReview. Unlike the rules based API (e.g. GetMetricsAccess), the result
+// is authoritative for all authorizers configured on the k8s cluster, not only RBAC.
+// It returns whether the action is allowed and the reason given by the authorizer, which may be empty.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - attributes describe the action, e.g. the "get" verb on a "managedclusters" resource named "devcluster1"
+// in the "cluster.open-cluster-management.io" group.
+func (r *AccessReviewer) CheckAccess(
+	ctx context.Context, userToken string, attributes authorizationv1.ResourceAttributes,
+) (bool, string, error) {
+	klog.V(2).Infof("CheckAccess for attributes: %v", attributes)
+
+	// get Client to talk to the Kubernetes cluster
+	userKClient, err := r.getKubeClientForUser(userToken)
+	if err != nil {
+		return false, "", err
+	}
+
+	return makeSelfSubjectAccessReview(ctx, userKClient, attributes)
+}
+
+// CheckAccessMany is the same as CheckAccess, but checks the user's access for each of the given
+// resource attributes. The SelfSubjectAccessReviews are made in parallel, with at most the number set
+// by WithConcurrency in-flight at a time. It returns a decision for each of the attributes, in the same order.
+// An error is only returned if the client to connect to the cluster could not be created, errors
+// of the individual checks are set on their decisions.
+func (r *AccessReviewer) CheckAccessMany(
+	ctx context.Context, userToken string, attributes []authorizationv1.ResourceAttributes,
+) ([]AccessDecision, error) {
+	klog.V(2).Infof("CheckAccessMany for %d attributes", len(attributes))
+
+	// get Client to talk to the Kubernetes cluster
+	userKClient, err := r.getKubeClientForUser(userToken)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]AccessDecision, len(attributes))
+
+	runConcurrently(len(attributes), r.getConcurrency(), func(i int) {
+		decision := AccessDecision{ResourceAttributes: attributes[i]}
+		decision.Allowed, decision.Reason, decision.Err = makeSelfSubjectAccessReview(ctx, userKClient, attributes[i])
+		decisions[i] = decision
+	})
+
+	return decisions, nil
+}
+
+// makeSelfSubjectAccessReview is a helper function that makes a SelfSubjectAccessReview call
+// on the k8s cluster for the given resource attributes. If the call is successful then it returns
+// whether the action is allowed and the reason for the decision.
+func makeSelfSubjectAccessReview(
+	ctx context.Context, kclient kubernetes.Interface, attributes authorizationv1.ResourceAttributes,
+) (bool, string, error) {
+	ssar := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+		},
+	}
+
+	response, err := kclient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, ssar, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", wrapAPIError(err)
+	}
+
+	ssarStatus := response.Status
+
+	// Log the evaluation error, the decision is still returned as it may be based on other authorizers
+	if ssarStatus.EvaluationError != "" {
+		klog.Infof("Encountered a SelfSubjectAccessReview error for attributes %v: %v",
+			attributes, ssarStatus.EvaluationError)
+	}
+
+	klog.V(2).Infof("Access review for attributes %v, allowed: %t, reason: %s",
+		attributes, ssarStatus.Allowed, ssarStatus.Reason)
+
+	return ssarStatus.Allowed, ssarStatus.Reason, nil
+}
@@ -0,0 +1,167 @@
+package rbac
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		username        string
+		kubeClient      kubernetes.Interface
+		attributes      authorizationv1.ResourceAttributes
+		expectedAllowed bool
+	}{
+		{"user-red", testUsers["user-red"].KubeClient, metricsAccessAttributes("devcluster1", "nsred1"), true},
+		{"user-red", testUsers["user-red"].KubeClient, metricsAccessAttributes("devcluster1", "nsblue1"), false},
+		{"user-red", testUsers["user-red"].KubeClient, metricsAccessAttributes("devcluster3", "nsred1"), false},
+		{"user-purple", testUsers["user-purple"].KubeClient, metricsAccessAttributes("devcluster2", "nsblue3"), true},
+		{
+			"user-sysadmin",
+			testUsers["user-sysadmin"].KubeClient,
+			metricsAccessAttributes("anycluster", "kube-system"),
+			true,
+		},
+		{"cluster-admin", baseK8sClient, metricsAccessAttributes("anycluster", "anynamespace"), true},
+		{
+			"user-view-all-default-namespace",
+			testUsers["user-view-all-default-namespace"].KubeClient,
+			authorizationv1.ResourceAttributes{Namespace: "default", Verb: "get", Resource: "configmaps"},
+			true,
+		},
+		{
+			"user-view-all-default-namespace",
+			testUsers["user-view-all-default-namespace"].KubeClient,
+			authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "get", Resource: "configmaps"},
+			false,
+		},
+	}
+
+	for _, test := range testcases {
+		rbacEngine, err := NewAccessReviewer(nil, test.kubeClient)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotAllowed, _, err := rbacEngine.CheckAccess(context.TODO(), "", test.attributes)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if gotAllowed != test.expectedAllowed {
+			t.Fatalf("user %s, attributes %v: expected allowed : %t , got  : %t",
+				test.username, test.attributes, test.expectedAllowed, gotAllowed)
+		}
+	}
+}
+
+func TestCheckAccessMany(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, err := NewAccessReviewer(nil, testUsers["user-blue"].KubeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	attributes := []authorizationv1.ResourceAttributes{
+		metricsAccessAttributes("devcluster1", "nsblue1"),
+		metricsAccessAttributes("devcluster1", "nsred1"),
+		metricsAccessAttributes("devcluster2", "nsblue3"),
+	}
+
+	decisions, err := rbacEngine.CheckAccessMany(context.TODO(), "", attributes)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedAllowed := []bool{true, false, true}
+
+	for i, decision := range decisions {
+		if decision.Err != nil {
+			t.Fatalf(decision.Err.Error())
+		}
+
+		if decision.ResourceAttributes != attributes[i] || decision.Allowed != expectedAllowed[i] {
+			t.Fatalf("expected decision for attributes %v : %t , got  : %v",
+				attributes[i], expectedAllowed[i], decision)
+		}
+	}
+}
+
+func TestCheckAccessManyConcurrency(t *testing.T) {
+	t.Parallel()
+
+	var (
+		lock        sync.Mutex
+		inflight    int
+		maxInflight int
+		calls       int32
+	)
+
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(&calls, 1)
+
+			lock.Lock()
+			inflight++
+			if inflight > maxInflight {
+				maxInflight = inflight
+			}
+			lock.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			lock.Lock()
+			inflight--
+			lock.Unlock()
+
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = review.Spec.ResourceAttributes.Name == "allowed"
+
+			return true, review, nil
+		})
+
+	rbacEngine, err := NewAccessReviewer(nil, fakeClient, WithConcurrency(3))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	attributes := make([]authorizationv1.ResourceAttributes, 20)
+	for i := range attributes {
+		attributes[i] = authorizationv1.ResourceAttributes{Verb: "get", Resource: "configmaps", Name: "denied"}
+		if i%2 == 0 {
+			attributes[i].Name = "allowed"
+		}
+	}
+
+	decisions, err := rbacEngine.CheckAccessMany(context.TODO(), "", attributes)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for i, decision := range decisions {
+		if decision.Allowed != (i%2 == 0) {
+			t.Fatalf("expected decision for attributes %v : %t , got  : %v", attributes[i], i%2 == 0, decision)
+		}
+	}
+
+	if atomic.LoadInt32(&calls) != int32(len(attributes)) {
+		t.Fatalf("expected num of access reviews : %d , got  : %d", len(attributes), atomic.LoadInt32(&calls))
+	}
+
+	if maxInflight > 3 {
+		t.Fatalf("expected at most %d access reviews in parallel, got  : %d", 3, maxInflight)
+	}
+}
@@ -0,0 +1,236 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// CacheResult tells whether the rules of the user were served from the rules cache for an audited call
+type CacheResult string
+
+const (
+	// CacheHit is set when all the rules of the user were served from the rules cache
+	CacheHit CacheResult = "hit"
+	// CacheMiss is set when some rules of the user were retrieved with a SelfSubjectRulesReview
+	CacheMiss CacheResult = "miss"
+)
+
+// redactedToken replaces the user's token in the audited error messages
+const redactedToken = "[REDACTED]"
+
+// AuditEvent records an access decision made by the AccessReviewer. It never holds the user's token.
+type AuditEvent struct {
+	// Time is when the call started
+	Time time.Time `json:"time"`
+	// Operation is the audited API, e.g. "GetMetricsAccess"
+	Operation string `json:"operation"`
+	// User is the identity of the user as returned by GetUserInfo, it is nil if it could not be resolved
+	User *UserInfo `json:"user,omitempty"`
+	// UserError is the reason why the identity of the user could not be resolved, if any
+	UserError string `json:"userError,omitempty"`
+	// RequestedClusters are the managed clusters passed to the call, empty if the access to all of them was asked
+	RequestedClusters []string `json:"requestedClusters"`
+	// Access is the map of managed clusters and namespaces returned to the caller, nil on errors
+	Access map[string][]string `json:"access,omitempty"`
+	// Cache tells whether the rules of the user were served from the rules cache, it is empty if the rules cache
+	// is not enabled or no rules were reviewed
+	Cache CacheResult `json:"cache,omitempty"`
+	// Latency is how long the call took, excluding the resolution of the user's identity
+	Latency time.Duration `json:"latency"`
+	// Error is the error returned to the caller, if any
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink receives the audit events of an AccessReviewer, it must be safe for concurrent use.
+// It is called synchronously once the access decision has been made, so it should not block for long.
+type AuditSink interface {
+	Audit(ctx context.Context, event AuditEvent)
+}
+
+// WithAuditSink enables the audit of the access decisions made by GetMetricsAccess, GetMetricsAccessDetails,
+// GetLogsAccess and GetPrefixedAccess. An AuditEvent is sent to the sink for every call, with the identity of
+// the user resolved with GetUserInfo, i.e. with a SelfSubjectReview or a TokenReview. The AccessReviewers
+// returned by Impersonate audit the impersonated user.
+func WithAuditSink(sink AuditSink) Option {
+	return func(r *AccessReviewer) {
+		r.auditSink = sink
+	}
+}
+
+// auditRecordKey is the context key of the auditRecord of the call being audited
+type auditRecordKey struct{}
+
+// auditRecord collects the details of an audited call made while computing the access decision
+type auditRecord struct {
+	lock  sync.Mutex
+	cache CacheResult
+}
+
+// recordCacheResult records whether a rules review of the audited call, if any, was served from the rules cache
+func recordCacheResult(ctx context.Context, hit bool) {
+	record, ok := ctx.Value(auditRecordKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	switch {
+	case !hit:
+		record.cache = CacheMiss
+	case record.cache == "":
+		record.cache = CacheHit
+	}
+}
+
+// audited calls fn and sends an AuditEvent for the call to the audit sink, if it is enabled.
+// Calls made by another audited call aren't audited on their own.
+func (r *AccessReviewer) audited(
+	ctx context.Context, operation string, userToken string, clusters []string,
+	fn func(ctx context.Context) (map[string][]string, error),
+) (map[string][]string, error) {
+	if r.auditSink == nil {
+		return fn(ctx)
+	}
+
+	if _, ok := ctx.Value(auditRecordKey{}).(*auditRecord); ok {
+		return fn(ctx)
+	}
+
+	record := &auditRecord{}
+	start := time.Now()
+
+	access, err := fn(context.WithValue(ctx, auditRecordKey{}, record))
+
+	event := AuditEvent{
+		Time:              start,
+		Operation:         operation,
+		RequestedClusters: clusters,
+		Latency:           time.Since(start),
+	}
+
+	if event.RequestedClusters == nil {
+		event.RequestedClusters = []string{}
+	}
+
+	record.lock.Lock()
+	event.Cache = record.cache
+	record.lock.Unlock()
+
+	if err != nil {
+		event.Error = redactToken(err.Error(), userToken)
+	} else {
+		event.Access = access
+	}
+
+	// the identity may have been resolved for the access result already
+	if userInfo, ok := ctx.Value(userInfoKey{}).(*UserInfo); ok {
+		event.User = userInfo
+	} else if r.kubeConfig == nil || userToken != "" {
+		userInfo, userErr := r.GetUserInfo(ctx, userToken)
+		if userErr != nil {
+			event.UserError = redactToken(userErr.Error(), userToken)
+		}
+
+		event.User = userInfo
+	}
+
+	r.auditSink.Audit(ctx, event)
+
+	return access, err
+}
+
+// redactToken replaces the token in the message, so that it is never audited
+func redactToken(message string, userToken string) string {
+	if userToken == "" {
+		return message
+	}
+
+	return strings.ReplaceAll(message, userToken, redactedToken)
+}
+
+// JSONLinesAuditSink writes the audit events as JSON lines, i.e. one JSON object per line.
+// It must be created with NewJSONLinesAuditSink or NewFileAuditSink.
+type JSONLinesAuditSink struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewJSONLinesAuditSink returns an AuditSink writing the events as JSON lines to the writer.
+func NewJSONLinesAuditSink(writer io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{encoder: json.NewEncoder(writer)}
+}
+
+// NewFileAuditSink returns an AuditSink appending the events as JSON lines to the file at the given path,
+// which is created if it doesn't exist. The file must be closed with Close.
+func NewFileAuditSink(path string) (*JSONLinesAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the audit file: %w", err)
+	}
+
+	return &JSONLinesAuditSink{encoder: json.NewEncoder(file), closer: file}, nil
+}
+
+// Audit writes the event as a JSON line, failures are logged as the access decision has already been made
+func (s *JSONLinesAuditSink) Audit(ctx context.Context, event AuditEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.encoder.Encode(event); err != nil {
+		klog.Infof("Failed to write the audit event for operation %s: %v", event.Operation, err)
+	}
+}
+
+// Close closes the file of a sink created with NewFileAuditSink, it is a no-op for other sinks.
+func (s *JSONLinesAuditSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
+
+// MemoryAuditSink keeps the audit events in memory, e.g. to check them in tests.
+// The zero value is ready to use.
+type MemoryAuditSink struct {
+	lock   sync.Mutex
+	events []AuditEvent
+}
+
+// Audit appends the event to the events of the sink
+func (s *MemoryAuditSink) Audit(ctx context.Context, event AuditEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.events = append(s.events, event)
+}
+
+// Events returns a copy of the events received by the sink, in the order they were received.
+func (s *MemoryAuditSink) Events() []AuditEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	events := make([]AuditEvent, len(s.events))
+	copy(events, s.events)
+
+	return events
+}
+
+// Reset drops the events received by the sink.
+func (s *MemoryAuditSink) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.events = nil
+}
@@ -0,0 +1,411 @@
+package rbac
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// auditHubToken is the token of the k8s config of the AccessReviewer in the audit tests
+const auditHubToken = "hub-token"
+
+// fakeAuthServer is a fake k8s API server that authenticates the hub token and the tokens of the configured users.
+// It responds to SelfSubjectRulesReviews with the rules configured for the bearer token of the request,
+// to the TokenReviews of the hub with the configured users and, if a version is configured, to SelfSubjectReviews
+// with the user of the request. Requests with other tokens are rejected with a 401 error whose message includes
+// the token.
+type fakeAuthServer struct {
+	*httptest.Server
+
+	lock sync.Mutex
+	// paths are the paths of the requests received
+	paths []string
+}
+
+func newFakeAuthServer(
+	t *testing.T, rulesByToken map[string][]authorizationv1.ResourceRule,
+	usersByToken map[string]authenticationv1.UserInfo, selfSubjectReviewVersion string,
+) *fakeAuthServer {
+	t.Helper()
+
+	fakeServer := &fakeAuthServer{}
+	fakeServer.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+		fakeServer.lock.Lock()
+		fakeServer.paths = append(fakeServer.paths, req.URL.Path)
+		fakeServer.lock.Unlock()
+
+		user, authenticated := usersByToken[token]
+		if !authenticated && token != auditHubToken {
+			writeFakeStatus(w, http.StatusUnauthorized, metav1.StatusReasonUnauthorized, "invalid bearer token "+token)
+
+			return
+		}
+
+		var response interface{}
+
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/tokenreviews") && token == auditHubToken:
+			review := &authenticationv1.TokenReview{}
+			if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			review.Kind = "TokenReview"
+			review.APIVersion = "authentication.k8s.io/v1"
+			review.Status.User, review.Status.Authenticated = usersByToken[review.Spec.Token]
+			response = review
+		case strings.HasSuffix(req.URL.Path, "/selfsubjectrulesreviews"):
+			review := &authorizationv1.SelfSubjectRulesReview{}
+			if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			review.Kind = "SelfSubjectRulesReview"
+			review.APIVersion = "authorization.k8s.io/v1"
+			review.Status.ResourceRules = rulesByToken[token]
+			response = review
+		case selfSubjectReviewVersion != "" &&
+			req.URL.Path == "/apis/authentication.k8s.io/"+selfSubjectReviewVersion+"/selfsubjectreviews":
+			// as the k8s API server, the response is encoded with protobuf if it is preferred by the client
+			if strings.HasPrefix(req.Header.Get("Accept"), "application/vnd.kubernetes.protobuf") {
+				w.Header().Set("Content-Type", "application/vnd.kubernetes.protobuf")
+				_, _ = w.Write([]byte("k8s\x00protobuf"))
+
+				return
+			}
+
+			review := &selfSubjectReview{}
+			if err := json.NewDecoder(req.Body).Decode(review); err != nil || review.Kind != "SelfSubjectReview" {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			review.Status.UserInfo = user
+			response = review
+		default:
+			writeFakeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "the server could not find the resource")
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(fakeServer.Close)
+
+	return fakeServer
+}
+
+// getPaths returns the paths of the requests received by the fake server
+func (s *fakeAuthServer) getPaths() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string{}, s.paths...)
+}
+
+// writeFakeStatus writes a k8s API error
+func writeFakeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(&metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+func newAuditedAccessReviewer(t *testing.T, sink AuditSink, opts ...Option) (*AccessReviewer, *fakeAuthServer) {
+	t.Helper()
+
+	fakeServer := newFakeAuthServer(t,
+		map[string][]authorizationv1.ResourceRule{"red-token": redMetricsRules},
+		map[string]authenticationv1.UserInfo{
+			"red-token": {Username: "reduser", UID: "1234", Groups: []string{"red-admins"}},
+		}, "")
+
+	rbacEngine, err := NewAccessReviewer(&rest.Config{Host: fakeServer.URL, BearerToken: auditHubToken}, nil,
+		append([]Option{WithAuditSink(sink)}, opts...)...)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return rbacEngine, fakeServer
+}
+
+// countTokenReviews returns the number of TokenReviews received by the fake server
+func countTokenReviews(fakeServer *fakeAuthServer) int {
+	reviews := 0
+
+	for _, path := range fakeServer.getPaths() {
+		if strings.HasSuffix(path, "/tokenreviews") {
+			reviews++
+		}
+	}
+
+	return reviews
+}
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	sink := &MemoryAuditSink{}
+	rbacEngine, fakeServer := newAuditedAccessReviewer(t, sink, WithRulesCache(time.Minute, 10))
+
+	expectedAccess := map[string][]string{"devcluster1": {"nsred1", "nsred2"}}
+	for i := 0; i < 2; i++ {
+		if _, err := rbacEngine.GetMetricsAccess("red-token", "devcluster1"); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	// nested calls are only audited once
+	if _, err := rbacEngine.GetLogsAccessWithContext(context.TODO(), "red-token"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	events := sink.Events()
+	if len(events) != 3 {
+		t.Fatalf("expected num of events : %d , got  : %d", 3, len(events))
+	}
+
+	expectedUser := &UserInfo{Name: "reduser", UID: "1234", Groups: []string{"red-admins"}}
+	expectedOperations := []string{"GetMetricsAccess", "GetMetricsAccess", "GetLogsAccess"}
+	expectedCache := []CacheResult{CacheMiss, CacheHit, CacheHit}
+
+	for i, event := range events {
+		if event.Operation != expectedOperations[i] {
+			t.Fatalf("expected operation : %s , got  : %s", expectedOperations[i], event.Operation)
+		}
+
+		if event.Cache != expectedCache[i] {
+			t.Fatalf("expected cache result : %s , got  : %s", expectedCache[i], event.Cache)
+		}
+
+		if !reflect.DeepEqual(event.User, expectedUser) {
+			t.Fatalf("expected user : %+v , got  : %+v", expectedUser, event.User)
+		}
+
+		if event.Time.IsZero() || event.Latency <= 0 || event.Error != "" || event.UserError != "" {
+			t.Fatalf("unexpected event : %+v", event)
+		}
+	}
+
+	if !reflect.DeepEqual(events[0].RequestedClusters, []string{"devcluster1"}) {
+		t.Fatalf("expected requested clusters : %v , got  : %v", []string{"devcluster1"}, events[0].RequestedClusters)
+	}
+
+	if !compareMetricsAccessResults(expectedAccess, events[0].Access) {
+		t.Fatalf("expected access : %v , got  : %v", expectedAccess, events[0].Access)
+	}
+
+	if len(events[2].RequestedClusters) != 0 || len(events[2].Access) != 0 {
+		t.Fatalf("expected no requested clusters and no logs access, got event : %+v", events[2])
+	}
+
+	// the identity is resolved once for the token, along with the cached rules
+	if reviews := countTokenReviews(fakeServer); reviews != 1 {
+		t.Fatalf("expected num of TokenReviews : %d , got  : %d", 1, reviews)
+	}
+
+	rbacEngine.Invalidate("red-token")
+
+	if _, err := rbacEngine.GetMetricsAccess("red-token", "devcluster1"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if reviews := countTokenReviews(fakeServer); reviews != 2 {
+		t.Fatalf("expected num of TokenReviews : %d , got  : %d", 2, reviews)
+	}
+
+	sink.Reset()
+
+	if len(sink.Events()) != 0 {
+		t.Fatalf("expected no events after a reset")
+	}
+}
+
+func TestAuditNeverLogsTokens(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	sink := NewJSONLinesAuditSink(&output)
+	rbacEngine, _ := newAuditedAccessReviewer(t, sink)
+
+	// the fake server includes the rejected token in its error message
+	_, err := rbacEngine.GetMetricsAccess("secret-token")
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected err: %s got err: %v", ErrUnauthenticated, err)
+	}
+
+	if !strings.Contains(err.Error(), "secret-token") {
+		t.Fatalf("expected the error returned to the caller to be unchanged, got err: %v", err)
+	}
+
+	if _, err := rbacEngine.GetMetricsAccess("red-token"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if strings.Contains(output.String(), "secret-token") || strings.Contains(output.String(), "red-token") ||
+		strings.Contains(output.String(), auditHubToken) {
+		t.Fatalf("expected no token in the audit events, got : %s", output.String())
+	}
+
+	scanner := bufio.NewScanner(&output)
+
+	var events []AuditEvent
+
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed to decode the audit event %q: %v", scanner.Text(), err)
+		}
+
+		events = append(events, event)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected num of events : %d , got  : %d", 2, len(events))
+	}
+
+	// the failed call has no access nor user, and the token is redacted from the error
+	if events[0].Access != nil || events[0].User != nil || !strings.Contains(events[0].Error, redactedToken) ||
+		events[0].UserError == "" {
+		t.Fatalf("unexpected event for a rejected token : %+v", events[0])
+	}
+
+	if events[1].User == nil || events[1].User.Name != "reduser" || events[1].Error != "" {
+		t.Fatalf("unexpected event : %+v", events[1])
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	for i := 0; i < 2; i++ {
+		sink, err := NewFileAuditSink(path)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		// the events are appended to the existing file
+		sink.Audit(context.TODO(), AuditEvent{Operation: "GetMetricsAccess", RequestedClusters: []string{}})
+
+		if err := sink.Close(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected num of lines : %d , got  : %d", 2, len(lines))
+	}
+
+	if _, err := NewFileAuditSink(filepath.Join(t.TempDir(), "missing", "audit.jsonl")); err == nil {
+		t.Fatalf("expected an error for a file in a missing directory")
+	}
+}
+
+func TestAuditWithKubeClient(t *testing.T) {
+	t.Parallel()
+
+	sink := &MemoryAuditSink{}
+
+	// the fake server doesn't serve SelfSubjectReviews
+	fakeServer := newFakeAuthServer(t,
+		map[string][]authorizationv1.ResourceRule{"red-token": redMetricsRules},
+		map[string]authenticationv1.UserInfo{"red-token": redUser}, "")
+
+	kclient, err := kubernetes.NewForConfig(&rest.Config{Host: fakeServer.URL, BearerToken: "red-token"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rbacEngine, err := NewAccessReviewer(nil, kclient, WithAuditSink(sink))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if _, err := rbacEngine.GetMetricsAccessDetails(context.TODO(), ""); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	events := sink.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected num of events : %d , got  : %d", 1, len(events))
+	}
+
+	// the identity isn't resolved and the cache isn't enabled
+	if events[0].Operation != "GetMetricsAccessDetails" || events[0].User != nil || events[0].Cache != "" {
+		t.Fatalf("unexpected event : %+v", events[0])
+	}
+
+	expectedAccess := map[string][]string{
+		"devcluster1": {"nsred1", "nsred2"},
+		"devcluster2": {"nsred1", "nsred2"},
+	}
+	if !compareMetricsAccessResults(expectedAccess, events[0].Access) {
+		t.Fatalf("expected access : %v , got  : %v", expectedAccess, events[0].Access)
+	}
+}
+
+func TestAuditImpersonatedUser(t *testing.T) {
+	t.Parallel()
+
+	sink := &MemoryAuditSink{}
+	rbacEngine, _ := newAuditedAccessReviewer(t, sink)
+
+	userInfo := UserInfo{Name: "reduser", Groups: []string{"red-admins"}}
+
+	impersonatingEngine, err := rbacEngine.Impersonate(userInfo)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if _, err := impersonatingEngine.GetMetricsAccess(""); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	events := sink.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected num of events : %d , got  : %d", 1, len(events))
+	}
+
+	if !reflect.DeepEqual(events[0].User, &userInfo) || events[0].UserError != "" {
+		t.Fatalf("expected user : %+v , got event : %+v", userInfo, events[0])
+	}
+}
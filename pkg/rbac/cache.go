@@ -0,0 +1,367 @@
+package rbac
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"k8s.io/klog"
+)
+
+// DefaultRulesCacheMaxEntries is the maximum number of entries held in the rules cache
+// when no positive value is passed to WithRulesCache.
+const DefaultRulesCacheMaxEntries = 1024
+
+// WithRulesCache enables caching of the user's SelfSubjectRulesReview results on the AccessReviewer,
+// so repeated access review calls for the same user are served in-memory. The identities of the users
+// resolved by GetUserInfo, e.g. for the audit events, are cached with the same settings.
+//
+// - ttl is how long a result is served from the cache before a new SelfSubjectRulesReview is made,
+// the cache is not enabled if it is not a positive value.
+//
+// - maxEntries is the maximum number of results held in the cache, the least recently used
+// result is evicted when it is full. If not a positive value, DefaultRulesCacheMaxEntries is used.
+func WithRulesCache(ttl time.Duration, maxEntries int) Option {
+	return func(r *AccessReviewer) {
+		if ttl <= 0 {
+			return
+		}
+
+		if maxEntries <= 0 {
+			maxEntries = DefaultRulesCacheMaxEntries
+		}
+
+		r.rulesCache = newRulesCache(ttl, maxEntries)
+		r.userInfoCache = newUserInfoCache(ttl, maxEntries)
+	}
+}
+
+// Invalidate drops all cached results and the cached identity for the user with the given token.
+// It is a no-op if the rules cache is not enabled.
+func (r *AccessReviewer) Invalidate(userToken string) {
+	if r.rulesCache != nil {
+		r.rulesCache.invalidate(r.cacheKeyHash(userToken))
+		r.userInfoCache.invalidate(userToken)
+	}
+}
+
+// Purge drops all cached results and identities. It is a no-op if the rules cache is not enabled.
+func (r *AccessReviewer) Purge() {
+	if r.rulesCache != nil {
+		r.rulesCache.purge()
+		r.userInfoCache.purge()
+	}
+}
+
+// rulesCache is a TTL based LRU cache of SelfSubjectRulesReview results, keyed by a hash of the user's token
+// and the namespace of the SelfSubjectRulesReview. Tokens are never stored in the cache.
+type rulesCache struct {
+	ttl        time.Duration
+	maxEntries int
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+
+	lock sync.Mutex
+	// lru holds the *rulesCacheEntry items, the most recently used at the front
+	lru     *list.List
+	entries map[rulesCacheKey]*list.Element
+	// globalGeneration is incremented when entries of any namespace are removed before they expire
+	globalGeneration uint64
+	// namespaceGenerations are incremented when the entries of a namespace are removed before they expire,
+	// so that the results of the rules reviews started before are not cached
+	namespaceGenerations map[string]uint64
+}
+
+// rulesCacheKey identifies the results of a SelfSubjectRulesReview made by a user in a namespace,
+// the tokenHash is the hash of the user's token or the key of an impersonated user, see cacheKeyHash
+type rulesCacheKey struct {
+	tokenHash string
+	namespace string
+}
+
+type rulesCacheEntry struct {
+	key         rulesCacheKey
+	rulesReview *RulesReviewResult
+	expiresAt   time.Time
+}
+
+func newRulesCache(ttl time.Duration, maxEntries int) *rulesCache {
+	return &rulesCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		lru:        list.New(),
+		entries:    make(map[rulesCacheKey]*list.Element, maxEntries),
+
+		namespaceGenerations: map[string]uint64{},
+	}
+}
+
+// hashToken returns a hex encoded SHA-256 hash of the user's token
+func hashToken(userToken string) string {
+	hash := sha256.Sum256([]byte(userToken))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// get returns the cached rules review for the user's token hash and namespace, if present and not expired
+func (c *rulesCache) get(tokenHash string, namespace string) (*RulesReviewResult, bool) {
+	key := rulesCacheKey{tokenHash: tokenHash, namespace: namespace}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*rulesCacheEntry)
+	if c.now().After(entry.expiresAt) {
+		c.removeElement(element)
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+
+	return entry.rulesReview, true
+}
+
+// generation returns the generation of the entries of the namespace, it changes whenever they are invalidated.
+// It is read before a rules review and passed to addIfCurrent once the review completes.
+func (c *rulesCache) generation(namespace string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.globalGeneration + c.namespaceGenerations[namespace]
+}
+
+// addIfCurrent caches the rules review for the user's token hash and namespace, unless the entries of the namespace
+// were invalidated since the given generation was read, as the rules review may predate the invalidation.
+// It returns true if the rules review was cached.
+func (c *rulesCache) addIfCurrent(
+	tokenHash string, namespace string, rulesReview *RulesReviewResult, generation uint64,
+) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.globalGeneration+c.namespaceGenerations[namespace] != generation {
+		return false
+	}
+
+	c.addLocked(rulesCacheKey{tokenHash: tokenHash, namespace: namespace}, rulesReview)
+
+	return true
+}
+
+// add caches the rules review for the user's token hash and namespace,
+// evicting the least recently used entry if full
+func (c *rulesCache) add(tokenHash string, namespace string, rulesReview *RulesReviewResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.addLocked(rulesCacheKey{tokenHash: tokenHash, namespace: namespace}, rulesReview)
+}
+
+// addLocked caches the rules review with the key, the lock must be held by the caller
+func (c *rulesCache) addLocked(key rulesCacheKey, rulesReview *RulesReviewResult) {
+	expiresAt := c.now().Add(c.ttl)
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*rulesCacheEntry)
+		entry.rulesReview = rulesReview
+		entry.expiresAt = expiresAt
+		c.lru.MoveToFront(element)
+
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&rulesCacheEntry{
+		key:         key,
+		rulesReview: rulesReview,
+		expiresAt:   expiresAt,
+	})
+
+	for c.lru.Len() > c.maxEntries {
+		klog.V(3).Infof("Rules cache is full, evicting the least recently used entry")
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// invalidate removes all entries for the user's token hash
+func (c *rulesCache) invalidate(tokenHash string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// the namespaces of the in-flight rules reviews of the user are unknown
+	c.globalGeneration++
+
+	for key, element := range c.entries {
+		if key.tokenHash == tokenHash {
+			c.removeElement(element)
+		}
+	}
+}
+
+// invalidateNamespaces removes the entries for the given namespaces, or all entries if all is true,
+// and returns the number of entries removed
+func (c *rulesCache) invalidateNamespaces(namespaces []string, all bool) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if all {
+		c.globalGeneration++
+	}
+
+	for _, namespace := range namespaces {
+		c.namespaceGenerations[namespace]++
+	}
+
+	removed := 0
+
+	for key, element := range c.entries {
+		if all || slices.Contains(namespaces, key.namespace) {
+			c.removeElement(element)
+
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// purge removes all entries
+func (c *rulesCache) purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.globalGeneration++
+	c.lru.Init()
+	c.entries = make(map[rulesCacheKey]*list.Element, c.maxEntries)
+}
+
+// len returns the number of entries in the cache, including expired ones not yet removed
+func (c *rulesCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.lru.Len()
+}
+
+// removeElement removes the element from the cache, the lock must be held by the caller
+func (c *rulesCache) removeElement(element *list.Element) {
+	entry := c.lru.Remove(element).(*rulesCacheEntry)
+	delete(c.entries, entry.key)
+}
+
+// userInfoCache is a TTL based LRU cache of the identities resolved by GetUserInfo, keyed by a hash of
+// the user's token. Tokens are never stored in the cache.
+type userInfoCache struct {
+	ttl        time.Duration
+	maxEntries int
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+
+	lock sync.Mutex
+	// lru holds the *userInfoCacheEntry items, the most recently used at the front
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type userInfoCacheEntry struct {
+	tokenHash string
+	userInfo  *UserInfo
+	expiresAt time.Time
+}
+
+func newUserInfoCache(ttl time.Duration, maxEntries int) *userInfoCache {
+	return &userInfoCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element, maxEntries),
+	}
+}
+
+// get returns a copy of the cached identity for the user's token, if present and not expired
+func (c *userInfoCache) get(userToken string) (*UserInfo, bool) {
+	tokenHash := hashToken(userToken)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[tokenHash]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*userInfoCacheEntry)
+	if c.now().After(entry.expiresAt) {
+		c.removeElement(element)
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+
+	return entry.userInfo.deepCopy(), true
+}
+
+// add caches a copy of the identity for the user's token, evicting the least recently used entry if full
+func (c *userInfoCache) add(userToken string, userInfo *UserInfo) {
+	tokenHash := hashToken(userToken)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+
+	if element, ok := c.entries[tokenHash]; ok {
+		entry := element.Value.(*userInfoCacheEntry)
+		entry.userInfo = userInfo.deepCopy()
+		entry.expiresAt = expiresAt
+		c.lru.MoveToFront(element)
+
+		return
+	}
+
+	c.entries[tokenHash] = c.lru.PushFront(&userInfoCacheEntry{
+		tokenHash: tokenHash,
+		userInfo:  userInfo.deepCopy(),
+		expiresAt: expiresAt,
+	})
+
+	for c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// invalidate removes the entry for the user's token
+func (c *userInfoCache) invalidate(userToken string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[hashToken(userToken)]; ok {
+		c.removeElement(element)
+	}
+}
+
+// purge removes all entries
+func (c *userInfoCache) purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element, c.maxEntries)
+}
+
+// removeElement removes the element from the cache, the lock must be held by the caller
+func (c *userInfoCache) removeElement(element *list.Element) {
+	entry := c.lru.Remove(element).(*userInfoCacheEntry)
+	delete(c.entries, entry.tokenHash)
+}
@@ -0,0 +1,139 @@
+package rbac
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var redMetricsRules = []authorizationv1.ResourceRule{
+	{
+		Verbs:         []string{"metrics/nsred1", "metrics/nsred2"},
+		APIGroups:     []string{"cluster.open-cluster-management.io"},
+		Resources:     []string{"managedclusters"},
+		ResourceNames: []string{"devcluster1", "devcluster2"},
+	},
+}
+
+var redMetricsReview = &RulesReviewResult{ResourceRules: redMetricsRules}
+
+// newFakeRulesClient returns a fake k8s client that responds to SelfSubjectRulesReviews with the given rules
+// and counts the number of SelfSubjectRulesReviews made
+func newFakeRulesClient(resourceRules []authorizationv1.ResourceRule, calls *int32) kubernetes.Interface {
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "selfsubjectrulesreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(calls, 1)
+
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+			review.Status.ResourceRules = resourceRules
+
+			return true, review, nil
+		})
+
+	return fakeClient
+}
+
+func TestRulesCache(t *testing.T) {
+	t.Parallel()
+
+	cache := newRulesCache(time.Minute, 2)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.add("token1", "", redMetricsReview)
+	cache.add("token1", "ns1", redMetricsReview)
+
+	if _, ok := cache.get("token1", ""); !ok {
+		t.Fatalf("expected cache hit for token1")
+	}
+
+	if _, ok := cache.get("token2", ""); ok {
+		t.Fatalf("expected cache miss for token2")
+	}
+
+	// token1/"" was used last, so token1/ns1 is evicted
+	cache.add("token2", "", redMetricsReview)
+
+	if _, ok := cache.get("token1", "ns1"); ok {
+		t.Fatalf("expected token1/ns1 to be evicted")
+	}
+
+	if cache.len() != 2 {
+		t.Fatalf("expected num of cache entries : %d , got  : %d", 2, cache.len())
+	}
+
+	// entries expire after the ttl
+	now = now.Add(2 * time.Minute)
+
+	if _, ok := cache.get("token1", ""); ok {
+		t.Fatalf("expected token1 entry to be expired")
+	}
+
+	// only the entries of the given token are invalidated
+	cache.add("token1", "", redMetricsReview)
+	cache.add("token2", "", redMetricsReview)
+	cache.invalidate("token1")
+
+	if _, ok := cache.get("token2", ""); !ok {
+		t.Fatalf("expected cache hit for token2")
+	}
+
+	if cache.len() != 1 {
+		t.Fatalf("expected num of cache entries : %d , got  : %d", 1, cache.len())
+	}
+
+	cache.purge()
+
+	if cache.len() != 0 {
+		t.Fatalf("expected num of cache entries : %d , got  : %d", 0, cache.len())
+	}
+}
+
+func TestGetMetricsAccessWithRulesCache(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+
+	rbacEngine, err := NewAccessReviewer(nil, newFakeRulesClient(redMetricsRules, &calls),
+		WithRulesCache(time.Minute, 0))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedResult := map[string][]string{
+		"devcluster1": {"nsred1", "nsred2"},
+		"devcluster2": {"nsred1", "nsred2"},
+	}
+
+	for i := 0; i < 3; i++ {
+		gotResult, err := rbacEngine.GetMetricsAccess("")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessResults(expectedResult, gotResult) {
+			t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+		}
+	}
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expected num of rules reviews : %d , got  : %d", 1, atomic.LoadInt32(&calls))
+	}
+
+	rbacEngine.Invalidate("")
+
+	if _, err := rbacEngine.GetMetricsAccess(""); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expected num of rules reviews : %d , got  : %d", 2, atomic.LoadInt32(&calls))
+	}
+}
@@ -0,0 +1,155 @@
+package rbac
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultClientPoolMaxClients is the maximum number of clients held in the client pool
+	// when no positive value is passed to WithClientPool.
+	DefaultClientPoolMaxClients = 256
+	// DefaultClientPoolIdleTimeout is how long an unused client is held in the client pool
+	// when no positive value is passed to WithClientPool.
+	DefaultClientPoolIdleTimeout = 5 * time.Minute
+)
+
+// WithClientPool enables pooling of the k8s clients created for the users' tokens, when the AccessReviewer
+// is created with a k8s config. All the pooled clients share a single underlying transport, so connections
+// to the cluster are reused across users and calls instead of being set up for every call.
+//
+// - maxClients is the maximum number of clients held in the pool, the least recently used client is
+// evicted when it is full. If not a positive value, DefaultClientPoolMaxClients is used.
+//
+// - idleTimeout is how long a client is held in the pool without being used before it is evicted.
+// If not a positive value, DefaultClientPoolIdleTimeout is used.
+func WithClientPool(maxClients int, idleTimeout time.Duration) Option {
+	return func(r *AccessReviewer) {
+		if maxClients <= 0 {
+			maxClients = DefaultClientPoolMaxClients
+		}
+
+		if idleTimeout <= 0 {
+			idleTimeout = DefaultClientPoolIdleTimeout
+		}
+
+		r.clientPool = newClientPool(maxClients, idleTimeout)
+	}
+}
+
+// clientPool is a LRU pool of k8s clients keyed by a hash of the user's token,
+// the clients share a single transport that is created on first use.
+type clientPool struct {
+	maxClients  int
+	idleTimeout time.Duration
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+
+	lock sync.Mutex
+	// transport is the transport shared by all clients, it does not hold any credentials
+	transport http.RoundTripper
+	// lru holds the *pooledClient items, the most recently used at the front
+	lru     *list.List
+	clients map[string]*list.Element
+}
+
+type pooledClient struct {
+	tokenHash string
+	client    kubernetes.Interface
+	lastUsed  time.Time
+}
+
+func newClientPool(maxClients int, idleTimeout time.Duration) *clientPool {
+	return &clientPool{
+		maxClients:  maxClients,
+		idleTimeout: idleTimeout,
+		now:         time.Now,
+		lru:         list.New(),
+		clients:     make(map[string]*list.Element, maxClients),
+	}
+}
+
+// get returns the pooled client for the user's token, creating it if needed.
+//
+// - anonymousConfig is the k8s config without any credentials, used to create the shared transport
+// and the client for the user's token.
+func (p *clientPool) get(anonymousConfig *rest.Config, userToken string) (kubernetes.Interface, error) {
+	tokenHash := hashToken(userToken)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.now()
+	p.evictIdle(now)
+
+	if element, ok := p.clients[tokenHash]; ok {
+		pooled := element.Value.(*pooledClient)
+		pooled.lastUsed = now
+		p.lru.MoveToFront(element)
+
+		return pooled.client, nil
+	}
+
+	if p.transport == nil {
+		sharedTransport, err := rest.TransportFor(anonymousConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		p.transport = sharedTransport
+	}
+
+	// the user's token is added to the requests on top of the shared transport
+	httpClient := &http.Client{
+		Transport: transport.NewBearerAuthRoundTripper(userToken, p.transport),
+		Timeout:   anonymousConfig.Timeout,
+	}
+
+	kclient, err := kubernetes.NewForConfigAndClient(anonymousConfig, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	p.clients[tokenHash] = p.lru.PushFront(&pooledClient{tokenHash: tokenHash, client: kclient, lastUsed: now})
+
+	for p.lru.Len() > p.maxClients {
+		klog.V(3).Infof("Client pool is full, evicting the least recently used client")
+		p.removeElement(p.lru.Back())
+	}
+
+	return kclient, nil
+}
+
+// evictIdle removes the clients that have not been used within the idle timeout,
+// the lock must be held by the caller
+func (p *clientPool) evictIdle(now time.Time) {
+	for element := p.lru.Back(); element != nil; element = p.lru.Back() {
+		if now.Sub(element.Value.(*pooledClient).lastUsed) <= p.idleTimeout {
+			return
+		}
+
+		klog.V(3).Infof("Evicting idle client from the client pool")
+		p.removeElement(element)
+	}
+}
+
+// len returns the number of clients in the pool
+func (p *clientPool) len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.lru.Len()
+}
+
+// removeElement removes the element from the pool, the lock must be held by the caller
+func (p *clientPool) removeElement(element *list.Element) {
+	pooled := p.lru.Remove(element).(*pooledClient)
+	delete(p.clients, pooled.tokenHash)
+}
@@ -0,0 +1,137 @@
+package rbac
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/rest"
+)
+
+// fakeRulesServer is a fake k8s API server that responds to SelfSubjectRulesReviews with
+// the rules configured for the bearer token of the request
+type fakeRulesServer struct {
+	*httptest.Server
+
+	lock sync.Mutex
+	// tokens are the bearer tokens of the requests received
+	tokens []string
+	// conns is the number of connections opened to the server
+	conns int
+}
+
+func newFakeRulesServer(t *testing.T, rulesByToken map[string][]authorizationv1.ResourceRule) *fakeRulesServer {
+	t.Helper()
+
+	fakeServer := &fakeRulesServer{}
+	fakeServer.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+		fakeServer.lock.Lock()
+		fakeServer.tokens = append(fakeServer.tokens, token)
+		fakeServer.lock.Unlock()
+
+		review := &authorizationv1.SelfSubjectRulesReview{}
+		if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		review.Kind = "SelfSubjectRulesReview"
+		review.APIVersion = "authorization.k8s.io/v1"
+		review.Status.ResourceRules = rulesByToken[token]
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(review)
+	}))
+	fakeServer.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			fakeServer.lock.Lock()
+			fakeServer.conns++
+			fakeServer.lock.Unlock()
+		}
+	}
+	fakeServer.Start()
+	t.Cleanup(fakeServer.Close)
+
+	return fakeServer
+}
+
+func TestClientPool(t *testing.T) {
+	t.Parallel()
+
+	fakeServer := newFakeRulesServer(t, map[string][]authorizationv1.ResourceRule{
+		"red-token": redMetricsRules,
+	})
+
+	rbacEngine, err := NewAccessReviewer(&rest.Config{Host: fakeServer.URL}, nil, WithClientPool(2, time.Minute))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	now := time.Now()
+	rbacEngine.clientPool.now = func() time.Time { return now }
+
+	expectedResults := map[string]map[string][]string{
+		"red-token":   {"devcluster1": {"nsred1", "nsred2"}, "devcluster2": {"nsred1", "nsred2"}},
+		"other-token": {},
+	}
+
+	for _, userToken := range []string{"red-token", "other-token", "red-token", "other-token"} {
+		gotResult, err := rbacEngine.GetMetricsAccess(userToken)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessResults(expectedResults[userToken], gotResult) {
+			t.Fatalf("expected result : %v , got  : %v", expectedResults[userToken], gotResult)
+		}
+	}
+
+	fakeServer.lock.Lock()
+	gotTokens := strings.Join(fakeServer.tokens, ",")
+	gotConns := fakeServer.conns
+	fakeServer.lock.Unlock()
+
+	// each request must carry the token of its user
+	if gotTokens != "red-token,other-token,red-token,other-token" {
+		t.Fatalf("expected tokens : %s , got  : %s", "red-token,other-token,red-token,other-token", gotTokens)
+	}
+
+	// the connection is reused across users as the clients share the transport
+	if gotConns != 1 {
+		t.Fatalf("expected num of connections : %d , got  : %d", 1, gotConns)
+	}
+
+	redClient, _ := rbacEngine.getKubeClientForUser("red-token")
+	if gotClient, _ := rbacEngine.getKubeClientForUser("red-token"); gotClient != redClient {
+		t.Fatalf("expected the pooled client to be reused for the same token")
+	}
+
+	// red-token was used last, so other-token is evicted
+	if _, err := rbacEngine.getKubeClientForUser("blue-token"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if _, ok := rbacEngine.clientPool.clients[hashToken("other-token")]; ok {
+		t.Fatalf("expected the client for other-token to be evicted")
+	}
+
+	// idle clients are evicted
+	now = now.Add(2 * time.Minute)
+
+	if _, err := rbacEngine.getKubeClientForUser("red-token"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if rbacEngine.clientPool.len() != 1 {
+		t.Fatalf("expected num of pooled clients : %d , got  : %d", 1, rbacEngine.clientPool.len())
+	}
+}
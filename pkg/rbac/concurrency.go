@@ -0,0 +1,60 @@
+package rbac
+
+import (
+	"sync"
+)
+
+// DefaultConcurrency is the maximum number of calls the AccessReviewer makes in parallel
+// to the k8s cluster for a single API invocation, when no positive value is passed to WithConcurrency.
+const DefaultConcurrency = 8
+
+// WithConcurrency sets the maximum number of calls the AccessReviewer makes in parallel to the k8s cluster
+// for a single API invocation that fans out, e.g. CheckAccessMany. If not a positive value,
+// DefaultConcurrency is used.
+func WithConcurrency(concurrency int) Option {
+	return func(r *AccessReviewer) {
+		if concurrency <= 0 {
+			concurrency = DefaultConcurrency
+		}
+
+		r.concurrency = concurrency
+	}
+}
+
+// getConcurrency returns the maximum number of calls to make in parallel
+func (r *AccessReviewer) getConcurrency() int {
+	if r.concurrency <= 0 {
+		return DefaultConcurrency
+	}
+
+	return r.concurrency
+}
+
+// runConcurrently invokes fn for each index in [0, count) with at most concurrency invocations
+// running in parallel, and returns once all of them complete.
+func runConcurrently(count int, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+
+		semaphore <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
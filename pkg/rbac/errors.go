@@ -0,0 +1,96 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var (
+	// ErrNoClientConfigured is returned by NewAccessReviewer when neither a k8s config nor a k8s client is set.
+	ErrNoClientConfigured = errors.New("one of either kubeConfig or kubeClient must be a non-nil value")
+	// ErrAmbiguousClientConfig is returned by NewAccessReviewer when both a k8s config and a k8s client are set.
+	ErrAmbiguousClientConfig = errors.New("only one of either kubeConfig or kubeClient must be a non-nil value")
+	// ErrMissingToken is returned by the access review API when the AccessReviewer was created with
+	// a k8s config and no user token is passed.
+	ErrMissingToken = errors.New(
+		"when KubeConfig is provided, a valid userToken must be set on all access review calls")
+	// ErrUnauthenticated is returned when the k8s cluster rejects the user's token (HTTP 401).
+	ErrUnauthenticated = errors.New("the user could not be authenticated")
+	// ErrForbidden is returned when the user isn't allowed to make the access review call (HTTP 403).
+	ErrForbidden = errors.New("the user is forbidden from making the access review")
+	// ErrIncompleteRules is returned, when strict rules are enabled on the AccessReviewer, if the rules of
+	// the user are incomplete. Use errors.As with an *IncompleteRulesError to get the details.
+	ErrIncompleteRules = errors.New("the user's rules returned by the SelfSubjectRulesReview are incomplete")
+	// ErrImpersonationUnsupported is returned by Impersonate when the AccessReviewer was created with a k8s client,
+	// as the impersonation settings can only be set on a k8s config.
+	ErrImpersonationUnsupported = errors.New(
+		"impersonation requires the AccessReviewer to be created with a KubeConfig")
+	// ErrMissingUser is returned by the API on behalf of a user when no user name is set in the UserInfo.
+	ErrMissingUser = errors.New("a user name must be set to review the access on behalf of a user")
+	// ErrUserInfoUnavailable is returned by GetUserInfo when the k8s cluster doesn't serve SelfSubjectReviews and
+	// no TokenReview can be made as the AccessReviewer was created with a k8s client.
+	ErrUserInfoUnavailable = errors.New("the user's identity could not be resolved")
+)
+
+// APIError wraps an error returned by the k8s cluster for an access review call. It matches with errors.Is
+// the sentinel error for its kind, e.g. ErrForbidden, while errors.As still gives access to the underlying
+// client-go error, e.g. a *k8s.io/apimachinery/pkg/api/errors.StatusError.
+type APIError struct {
+	// Kind is the sentinel error for the kind of failure, i.e. ErrUnauthenticated or ErrForbidden
+	Kind error
+	// Err is the error returned by the k8s cluster
+	Err error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+// Unwrap returns the error returned by the k8s cluster
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is the sentinel error for the kind of failure
+func (e *APIError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// IncompleteRulesError is the error returned when the rules of the user are incomplete,
+// it matches ErrIncompleteRules with errors.Is.
+type IncompleteRulesError struct {
+	// Namespace is the namespace of the SelfSubjectRulesReview
+	Namespace string
+	// EvaluationError is the evaluation error returned by the SelfSubjectRulesReview, it may be empty
+	EvaluationError string
+}
+
+func (e *IncompleteRulesError) Error() string {
+	if e.EvaluationError == "" {
+		return fmt.Sprintf("%s in namespace %q", ErrIncompleteRules, e.Namespace)
+	}
+
+	return fmt.Sprintf("%s in namespace %q: %s", ErrIncompleteRules, e.Namespace, e.EvaluationError)
+}
+
+// Is reports whether the target is ErrIncompleteRules
+func (e *IncompleteRulesError) Is(target error) bool {
+	return target == ErrIncompleteRules
+}
+
+// wrapAPIError wraps the error returned by the k8s cluster in an *APIError if it is one of the known kinds,
+// otherwise the error is returned as is.
+func wrapAPIError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsUnauthorized(err):
+		return &APIError{Kind: ErrUnauthenticated, Err: err}
+	case apierrors.IsForbidden(err):
+		return &APIError{Kind: ErrForbidden, Err: err}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,72 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAccessReviewErrors(t *testing.T) {
+	t.Parallel()
+
+	reviewsResource := schema.GroupResource{Group: "authorization.k8s.io", Resource: "selfsubjectrulesreviews"}
+
+	testcases := []struct {
+		apiErr      error
+		expectedErr error
+	}{
+		{apierrors.NewUnauthorized("invalid bearer token"), ErrUnauthenticated},
+		{apierrors.NewForbidden(reviewsResource, "", errors.New("not allowed")), ErrForbidden},
+	}
+
+	for _, test := range testcases {
+		apiErr := test.apiErr
+		fakeClient := fake.NewSimpleClientset()
+		fakeClient.PrependReactor("create", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apiErr
+		})
+
+		rbacEngine, err := NewAccessReviewer(nil, fakeClient)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		_, err = rbacEngine.GetMetricsAccess("")
+		if !errors.Is(err, test.expectedErr) {
+			t.Fatalf("expected err: %s got err: %v", test.expectedErr, err)
+		}
+
+		// the client-go error is still available
+		var statusErr *apierrors.StatusError
+		if !errors.As(err, &statusErr) || statusErr != apiErr {
+			t.Fatalf("expected err to wrap: %v got err: %v", apiErr, err)
+		}
+
+		_, _, err = rbacEngine.CheckAccess(context.TODO(), "", authorizationv1.ResourceAttributes{Verb: "get"})
+		if !errors.Is(err, test.expectedErr) {
+			t.Fatalf("expected err: %s got err: %v", test.expectedErr, err)
+		}
+	}
+}
+
+func TestMissingTokenError(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, err := NewAccessReviewer(&rest.Config{Host: "https://127.0.0.1:6443"}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err = rbacEngine.GetMetricsAccess("")
+	if !errors.Is(err, ErrMissingToken) {
+		t.Fatalf("expected err: %s got err: %v", ErrMissingToken, err)
+	}
+}
@@ -0,0 +1,282 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+// selfSubjectReviewVersions are the versions of the authentication.k8s.io API serving SelfSubjectReviews,
+// from the most to the least stable
+var selfSubjectReviewVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+// selfSubjectReviewUnsupported is the cached version when the k8s cluster doesn't serve SelfSubjectReviews
+const selfSubjectReviewUnsupported = "-"
+
+// selfSubjectReview is the subset of the SelfSubjectReview API used to get the identity of the user,
+// it is the same in all the versions of the API
+type selfSubjectReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Status          struct {
+		UserInfo authenticationv1.UserInfo `json:"userInfo"`
+	} `json:"status"`
+}
+
+// AccessResult is the user's access along with the identity of the user.
+type AccessResult struct {
+	// User is the identity of the user, it is only set when WithUserInfo is set on the AccessReviewer
+	User *UserInfo `json:"user,omitempty"`
+	// Access is the map of managed clusters and namespaces, as returned by GetMetricsAccess or GetLogsAccess
+	Access map[string][]string `json:"access"`
+}
+
+// WithUserInfo attaches the identity of the user, resolved with GetUserInfo, to the results of
+// GetMetricsAccessResult and GetLogsAccessResult. The identity is also reused by the audit events,
+// see WithAuditSink.
+func WithUserInfo() Option {
+	return func(r *AccessReviewer) {
+		r.attachUserInfo = true
+	}
+}
+
+// userInfoKey is the context key of the identity of the user already resolved for a call
+type userInfoKey struct{}
+
+// GetUserInfo returns the identity of the user with the token, i.e. name, UID, groups and extra fields,
+// as authenticated by the k8s cluster. It makes a SelfSubjectReview with the user's token when the
+// k8s cluster serves them, and otherwise falls back to a TokenReview made with the identity of
+// the k8s config set on the AccessReviewer, which must be allowed to create TokenReviews.
+// The API version serving SelfSubjectReviews is discovered on the first call and then reused.
+// When the rules cache is enabled, the identities are cached with the same TTL, see WithRulesCache.
+// The AccessReviewers returned by Impersonate return the impersonated user.
+//
+// An ErrUnauthenticated error is returned if the token is rejected and an ErrUserInfoUnavailable error
+// if the k8s cluster doesn't serve SelfSubjectReviews and the AccessReviewer was created with a k8s client.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+func (r *AccessReviewer) GetUserInfo(ctx context.Context, userToken string) (*UserInfo, error) {
+	if r.impersonatedUser != nil {
+		userInfo := *r.impersonatedUser
+
+		return &userInfo, nil
+	}
+
+	if r.userInfoCache != nil {
+		if userInfo, ok := r.userInfoCache.get(userToken); ok {
+			klog.V(2).Info("User's identity served from the cache")
+
+			return userInfo, nil
+		}
+	}
+
+	userInfo, err := r.resolveUserInfo(ctx, userToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.userInfoCache != nil {
+		r.userInfoCache.add(userToken, userInfo)
+	}
+
+	return userInfo, nil
+}
+
+// resolveUserInfo returns the identity of the user with the token with a SelfSubjectReview, or a TokenReview
+// if the k8s cluster doesn't serve SelfSubjectReviews
+func (r *AccessReviewer) resolveUserInfo(ctx context.Context, userToken string) (*UserInfo, error) {
+	userKClient, err := r.getKubeClientForUser(userToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userInfo, err := r.makeSelfSubjectReview(ctx, userKClient)
+	if err == nil || !apierrors.IsForbidden(err) && !apierrors.IsNotFound(err) {
+		return userInfo, wrapAPIError(err)
+	}
+
+	klog.V(2).Infof("Falling back to a TokenReview as the SelfSubjectReview failed: %v", err)
+
+	if r.kubeConfig == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUserInfoUnavailable, err)
+	}
+
+	return r.reviewToken(ctx, userToken)
+}
+
+// GetMetricsAccessResult is the same as GetMetricsAccessWithContext, but it also returns the identity of the user
+// when WithUserInfo is set on the AccessReviewer.
+func (r *AccessReviewer) GetMetricsAccessResult(
+	ctx context.Context, userToken string, clusters ...string,
+) (*AccessResult, error) {
+	return r.getAccessResult(ctx, userToken, func(ctx context.Context) (map[string][]string, error) {
+		return r.GetMetricsAccessWithContext(ctx, userToken, clusters...)
+	})
+}
+
+// GetLogsAccessResult is the same as GetLogsAccessWithContext, but it also returns the identity of the user
+// when WithUserInfo is set on the AccessReviewer.
+func (r *AccessReviewer) GetLogsAccessResult(
+	ctx context.Context, userToken string, clusters ...string,
+) (*AccessResult, error) {
+	return r.getAccessResult(ctx, userToken, func(ctx context.Context) (map[string][]string, error) {
+		return r.GetLogsAccessWithContext(ctx, userToken, clusters...)
+	})
+}
+
+// getAccessResult resolves the identity of the user, if it is attached to the results, and returns it
+// with the access returned by fn. The identity is passed to fn in the context so that it is resolved once.
+func (r *AccessReviewer) getAccessResult(
+	ctx context.Context, userToken string, fn func(ctx context.Context) (map[string][]string, error),
+) (*AccessResult, error) {
+	result := &AccessResult{}
+
+	if r.attachUserInfo {
+		userInfo, err := r.GetUserInfo(ctx, userToken)
+		if err != nil {
+			return nil, err
+		}
+
+		result.User = userInfo
+		ctx = context.WithValue(ctx, userInfoKey{}, userInfo)
+	}
+
+	access, err := fn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Access = access
+
+	return result, nil
+}
+
+// makeSelfSubjectReview returns the identity of the user of the k8s client with a SelfSubjectReview.
+// A NotFound error is returned if the k8s cluster doesn't serve SelfSubjectReviews.
+func (r *AccessReviewer) makeSelfSubjectReview(ctx context.Context, kclient kubernetes.Interface) (*UserInfo, error) {
+	restClient := kclient.AuthenticationV1().RESTClient()
+	versions := selfSubjectReviewVersions
+
+	r.identityLock.Lock()
+	knownVersion := r.selfSubjectReviewVersion
+	r.identityLock.Unlock()
+
+	switch knownVersion {
+	case "":
+	case selfSubjectReviewUnsupported:
+		return nil, apierrors.NewNotFound(authenticationv1.Resource("selfsubjectreviews"), "")
+	default:
+		versions = []string{knownVersion}
+	}
+
+	var err error
+
+	for _, version := range versions {
+		var userInfo *UserInfo
+
+		userInfo, err = postSelfSubjectReview(ctx, restClient, version)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+
+		if err == nil {
+			r.setSelfSubjectReviewVersion(version)
+		}
+
+		return userInfo, err
+	}
+
+	r.setSelfSubjectReviewVersion(selfSubjectReviewUnsupported)
+
+	return nil, err
+}
+
+// setSelfSubjectReviewVersion caches the version of the API serving SelfSubjectReviews
+func (r *AccessReviewer) setSelfSubjectReviewVersion(version string) {
+	r.identityLock.Lock()
+	defer r.identityLock.Unlock()
+
+	r.selfSubjectReviewVersion = version
+}
+
+// postSelfSubjectReview makes a SelfSubjectReview with the given version of the authentication.k8s.io API
+func postSelfSubjectReview(ctx context.Context, restClient rest.Interface, version string) (*UserInfo, error) {
+	apiVersion := authenticationv1.GroupName + "/" + version
+
+	body, err := json.Marshal(&selfSubjectReview{
+		TypeMeta: metav1.TypeMeta{Kind: "SelfSubjectReview", APIVersion: apiVersion},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := restClient.Post().
+		AbsPath("/apis", apiVersion, "selfsubjectreviews").
+		// the response is decoded as JSON, whatever the content type of the k8s config, e.g. protobuf
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Accept", "application/json").
+		Body(body).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	review := &selfSubjectReview{}
+	if err := json.Unmarshal(raw, review); err != nil {
+		return nil, fmt.Errorf("failed to decode the SelfSubjectReview: %w", err)
+	}
+
+	return userInfoFromAuthentication(review.Status.UserInfo), nil
+}
+
+// reviewToken returns the identity of the user with the token, as authenticated by a TokenReview made
+// with the identity of the k8s config set on the AccessReviewer
+func (r *AccessReviewer) reviewToken(ctx context.Context, userToken string) (*UserInfo, error) {
+	hubKClient, err := r.getHubKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenReview, err := hubKClient.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: userToken},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, wrapAPIError(err)
+	}
+
+	if !tokenReview.Status.Authenticated {
+		if tokenReview.Status.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, tokenReview.Status.Error)
+		}
+
+		return nil, ErrUnauthenticated
+	}
+
+	return userInfoFromAuthentication(tokenReview.Status.User), nil
+}
+
+// userInfoFromAuthentication returns the UserInfo of the user authenticated by a TokenReview or
+// a SelfSubjectReview
+func userInfoFromAuthentication(user authenticationv1.UserInfo) *UserInfo {
+	userInfo := &UserInfo{
+		Name:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+	}
+
+	if len(user.Extra) > 0 {
+		userInfo.Extra = make(map[string][]string, len(user.Extra))
+		for key, values := range user.Extra {
+			userInfo.Extra[key] = values
+		}
+	}
+
+	return userInfo
+}
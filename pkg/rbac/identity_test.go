@@ -0,0 +1,295 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var redUser = authenticationv1.UserInfo{
+	Username: "reduser",
+	UID:      "1234",
+	Groups:   []string{"red-admins", "system:authenticated"},
+	Extra:    map[string]authenticationv1.ExtraValue{"scopes": {"user:full"}},
+}
+
+var expectedRedUser = &UserInfo{
+	Name:   "reduser",
+	UID:    "1234",
+	Groups: []string{"red-admins", "system:authenticated"},
+	Extra:  map[string][]string{"scopes": {"user:full"}},
+}
+
+func newIdentityAccessReviewer(
+	t *testing.T, selfSubjectReviewVersion string, opts ...Option,
+) (*AccessReviewer, *fakeAuthServer) {
+	t.Helper()
+
+	fakeServer := newFakeAuthServer(t,
+		map[string][]authorizationv1.ResourceRule{"red-token": redMetricsRules},
+		map[string]authenticationv1.UserInfo{"red-token": redUser},
+		selfSubjectReviewVersion)
+
+	rbacEngine, err := NewAccessReviewer(&rest.Config{Host: fakeServer.URL, BearerToken: auditHubToken}, nil, opts...)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return rbacEngine, fakeServer
+}
+
+func TestGetUserInfo(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		selfSubjectReviewVersion string
+		// expectedPaths are the requests of the first call and of the next calls
+		expectedPaths []string
+	}{
+		{
+			"v1",
+			[]string{
+				"/apis/authentication.k8s.io/v1/selfsubjectreviews",
+				"/apis/authentication.k8s.io/v1/selfsubjectreviews",
+			},
+		},
+		{ // the version is discovered on the first call
+			"v1beta1",
+			[]string{
+				"/apis/authentication.k8s.io/v1/selfsubjectreviews",
+				"/apis/authentication.k8s.io/v1beta1/selfsubjectreviews",
+				"/apis/authentication.k8s.io/v1beta1/selfsubjectreviews",
+			},
+		},
+		{ // TokenReviews are used when SelfSubjectReviews aren't served
+			"",
+			[]string{
+				"/apis/authentication.k8s.io/v1/selfsubjectreviews",
+				"/apis/authentication.k8s.io/v1beta1/selfsubjectreviews",
+				"/apis/authentication.k8s.io/v1alpha1/selfsubjectreviews",
+				"/apis/authentication.k8s.io/v1/tokenreviews",
+				"/apis/authentication.k8s.io/v1/tokenreviews",
+			},
+		},
+	}
+
+	for _, test := range testcases {
+		rbacEngine, fakeServer := newIdentityAccessReviewer(t, test.selfSubjectReviewVersion)
+
+		for i := 0; i < 2; i++ {
+			userInfo, err := rbacEngine.GetUserInfo(context.TODO(), "red-token")
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			if !reflect.DeepEqual(userInfo, expectedRedUser) {
+				t.Fatalf("expected user : %+v , got  : %+v", expectedRedUser, userInfo)
+			}
+		}
+
+		if paths := fakeServer.getPaths(); !reflect.DeepEqual(paths, test.expectedPaths) {
+			t.Fatalf("expected requests : %v , got  : %v", test.expectedPaths, paths)
+		}
+	}
+}
+
+func TestGetUserInfoErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, selfSubjectReviewVersion := range []string{"v1", ""} {
+		rbacEngine, _ := newIdentityAccessReviewer(t, selfSubjectReviewVersion)
+
+		_, err := rbacEngine.GetUserInfo(context.TODO(), "invalid-token")
+		if !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("expected err: %s got err: %v", ErrUnauthenticated, err)
+		}
+
+		_, err = rbacEngine.GetUserInfo(context.TODO(), "")
+		if !errors.Is(err, ErrMissingToken) {
+			t.Fatalf("expected err: %s got err: %v", ErrMissingToken, err)
+		}
+	}
+
+	// no TokenReview can be made without a k8s config
+	fakeServer := newFakeAuthServer(t, nil, map[string]authenticationv1.UserInfo{"red-token": redUser}, "")
+
+	kclient, err := kubernetes.NewForConfig(&rest.Config{Host: fakeServer.URL, BearerToken: "red-token"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rbacEngine, err := NewAccessReviewer(nil, kclient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err = rbacEngine.GetUserInfo(context.TODO(), "")
+	if !errors.Is(err, ErrUserInfoUnavailable) {
+		t.Fatalf("expected err: %s got err: %v", ErrUserInfoUnavailable, err)
+	}
+}
+
+func TestGetUserInfoCache(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, fakeServer := newIdentityAccessReviewer(t, "v1", WithRulesCache(time.Minute, 10))
+
+	for i := 0; i < 2; i++ {
+		userInfo, err := rbacEngine.GetUserInfo(context.TODO(), "red-token")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !reflect.DeepEqual(userInfo, expectedRedUser) {
+			t.Fatalf("expected user : %+v , got  : %+v", expectedRedUser, userInfo)
+		}
+
+		// the cached identity can't be modified by the caller
+		userInfo.Groups[0] = "blue-admins"
+	}
+
+	if paths := fakeServer.getPaths(); len(paths) != 1 {
+		t.Fatalf("expected a single SelfSubjectReview, got  : %v", paths)
+	}
+
+	// rejected tokens aren't cached
+	for i := 0; i < 2; i++ {
+		if _, err := rbacEngine.GetUserInfo(context.TODO(), "invalid-token"); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("expected err: %s got err: %v", ErrUnauthenticated, err)
+		}
+	}
+
+	if paths := fakeServer.getPaths(); len(paths) != 3 {
+		t.Fatalf("expected num of requests : %d , got  : %v", 3, paths)
+	}
+
+	rbacEngine.Purge()
+
+	if _, err := rbacEngine.GetUserInfo(context.TODO(), "red-token"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if paths := fakeServer.getPaths(); len(paths) != 4 {
+		t.Fatalf("expected num of requests : %d , got  : %v", 4, paths)
+	}
+}
+
+func TestGetUserInfoProtobuf(t *testing.T) {
+	t.Parallel()
+
+	fakeServer := newFakeAuthServer(t, nil, map[string]authenticationv1.UserInfo{"red-token": redUser}, "v1")
+
+	// the SelfSubjectReviews are decoded as JSON when the k8s config prefers protobuf
+	rbacEngine, err := NewAccessReviewer(&rest.Config{
+		Host:          fakeServer.URL,
+		BearerToken:   auditHubToken,
+		ContentConfig: rest.ContentConfig{ContentType: "application/vnd.kubernetes.protobuf"},
+	}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	userInfo, err := rbacEngine.GetUserInfo(context.TODO(), "red-token")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !reflect.DeepEqual(userInfo, expectedRedUser) {
+		t.Fatalf("expected user : %+v , got  : %+v", expectedRedUser, userInfo)
+	}
+}
+
+func TestGetUserInfoImpersonated(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, fakeServer := newIdentityAccessReviewer(t, "v1")
+
+	userInfo := UserInfo{Name: "reduser", Groups: []string{"red-admins"}}
+
+	impersonatingEngine, err := rbacEngine.Impersonate(userInfo)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	gotUserInfo, err := impersonatingEngine.GetUserInfo(context.TODO(), "")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !reflect.DeepEqual(gotUserInfo, &userInfo) {
+		t.Fatalf("expected user : %+v , got  : %+v", userInfo, gotUserInfo)
+	}
+
+	if paths := fakeServer.getPaths(); len(paths) != 0 {
+		t.Fatalf("expected no requests, got  : %v", paths)
+	}
+}
+
+func TestGetMetricsAccessResult(t *testing.T) {
+	t.Parallel()
+
+	expectedAccess := map[string][]string{"devcluster1": {"nsred1", "nsred2"}}
+
+	// without WithUserInfo, no user is attached
+	rbacEngine, _ := newIdentityAccessReviewer(t, "v1")
+
+	result, err := rbacEngine.GetMetricsAccessResult(context.TODO(), "red-token", "devcluster1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if result.User != nil || !compareMetricsAccessResults(expectedAccess, result.Access) {
+		t.Fatalf("unexpected result : %+v", result)
+	}
+
+	sink := &MemoryAuditSink{}
+	rbacEngine, fakeServer := newIdentityAccessReviewer(t, "v1", WithUserInfo(), WithAuditSink(sink))
+
+	result, err = rbacEngine.GetMetricsAccessResult(context.TODO(), "red-token", "devcluster1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !reflect.DeepEqual(result.User, expectedRedUser) || !compareMetricsAccessResults(expectedAccess, result.Access) {
+		t.Fatalf("unexpected result : %+v", result)
+	}
+
+	// the identity is resolved once for the result and the audit event
+	reviews := 0
+
+	for _, path := range fakeServer.getPaths() {
+		if strings.HasSuffix(path, "/selfsubjectreviews") {
+			reviews++
+		}
+	}
+
+	if reviews != 1 {
+		t.Fatalf("expected num of SelfSubjectReviews : %d , got  : %d", 1, reviews)
+	}
+
+	if events := sink.Events(); len(events) != 1 || !reflect.DeepEqual(events[0].User, expectedRedUser) {
+		t.Fatalf("expected an audit event for user %+v , got  : %+v", expectedRedUser, events)
+	}
+
+	logsResult, err := rbacEngine.GetLogsAccessResult(context.TODO(), "red-token")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !reflect.DeepEqual(logsResult.User, expectedRedUser) || len(logsResult.Access) != 0 {
+		t.Fatalf("unexpected result : %+v", logsResult)
+	}
+
+	// no result is returned when the token is rejected
+	if _, err := rbacEngine.GetMetricsAccessResult(context.TODO(), "invalid-token"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected err: %s got err: %v", ErrUnauthenticated, err)
+	}
+}
@@ -0,0 +1,216 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+	"k8s.io/klog"
+)
+
+// UserInfo describes a user as authenticated by the k8s cluster, i.e. the same information as
+// k8s.io/apiserver/pkg/authentication/user.Info, to review the user's access without the user's token.
+type UserInfo struct {
+	// Name is the name that uniquely identifies the user
+	Name string `json:"name"`
+	// UID is a unique value for the user across time, it may be empty
+	UID string `json:"uid,omitempty"`
+	// Groups are the names of the groups the user is a member of
+	Groups []string `json:"groups,omitempty"`
+	// Extra holds any additional information provided by the authenticator, e.g. scopes
+	Extra map[string][]string `json:"extra,omitempty"`
+}
+
+// deepCopy returns a copy of the user that shares no slice or map with it
+func (u *UserInfo) deepCopy() *UserInfo {
+	userInfo := &UserInfo{Name: u.Name, UID: u.UID}
+
+	if u.Groups != nil {
+		userInfo.Groups = append([]string{}, u.Groups...)
+	}
+
+	if u.Extra != nil {
+		userInfo.Extra = make(map[string][]string, len(u.Extra))
+		for key, values := range u.Extra {
+			userInfo.Extra[key] = append([]string{}, values...)
+		}
+	}
+
+	return userInfo
+}
+
+// Impersonate returns an AccessReviewer whose API reviews the access of the given user without the user's token,
+// by impersonating the user with the identity of the k8s config set on the AccessReviewer, which must be allowed
+// to impersonate users, groups and extra fields. The API of the returned AccessReviewer, e.g. GetMetricsAccess,
+// is called with an empty userToken.
+// The clients of all the returned AccessReviewers share a single transport with the identity of the k8s config,
+// so connections to the cluster are reused across the impersonated users.
+// The returned AccessReviewer carries over the concurrency, metrics verification, strict rules, audit sink and
+// user info settings of the AccessReviewer. It shares the rules cache, in which the results of the impersonated
+// user are kept apart from the ones of the users' tokens, and they are dropped by the RBAC invalidation as well.
+// Its Invalidate method drops the cached results of the impersonated user. The client pool doesn't apply to it
+// as it doesn't use the users' tokens.
+//
+// An ErrImpersonationUnsupported error is returned if the AccessReviewer was created with a k8s client and an
+// ErrMissingUser error if the user's name is not set.
+//
+// - userInfo describes the user, e.g. as known from the authentication of a request or a background job
+func (r *AccessReviewer) Impersonate(userInfo UserInfo) (*AccessReviewer, error) {
+	if r.kubeConfig == nil {
+		return nil, ErrImpersonationUnsupported
+	}
+
+	if userInfo.Name == "" {
+		return nil, ErrMissingUser
+	}
+
+	klog.V(2).Infof("Impersonate user: %s, groups: %v", userInfo.Name, userInfo.Groups)
+
+	hubTransport, err := r.getHubTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	// the impersonation headers are added to the requests on top of the shared transport
+	httpClient := &http.Client{
+		Transport: transport.NewImpersonatingRoundTripper(transport.ImpersonationConfig{
+			UserName: userInfo.Name,
+			UID:      userInfo.UID,
+			Groups:   userInfo.Groups,
+			Extra:    userInfo.Extra,
+		}, hubTransport),
+		Timeout: r.kubeConfig.Timeout,
+	}
+
+	impersonatedKClient, err := kubernetes.NewForConfigAndClient(rest.CopyConfig(r.kubeConfig), httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	userInfoHash, err := impersonatedUserHash(userInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessReviewer{
+		kubeClient:           impersonatedKClient,
+		rulesCache:           r.rulesCache,
+		userInfoCache:        r.userInfoCache,
+		inflightReviews:      r.inflightReviews,
+		concurrency:          r.concurrency,
+		metricsVerification:  r.metricsVerification,
+		strictRules:          r.strictRules,
+		auditSink:            r.auditSink,
+		impersonatedUser:     &userInfo,
+		impersonatedUserHash: userInfoHash,
+		attachUserInfo:       r.attachUserInfo,
+	}, nil
+}
+
+// getHubTransport returns the transport with the identity of the k8s config set on the AccessReviewer,
+// creating it on first use
+func (r *AccessReviewer) getHubTransport() (http.RoundTripper, error) {
+	r.transportLock.Lock()
+	defer r.transportLock.Unlock()
+
+	if r.hubTransport == nil {
+		// the impersonation of the k8s config, if any, is replaced by the one of the impersonated users
+		hubConfig := rest.CopyConfig(r.kubeConfig)
+		hubConfig.Impersonate = rest.ImpersonationConfig{}
+
+		hubTransport, err := rest.TransportFor(hubConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get a transport to connect to the kubernetes cluster: %w", err)
+		}
+
+		r.hubTransport = hubTransport
+	}
+
+	return r.hubTransport, nil
+}
+
+// impersonatedUserHash returns the key of the impersonated user in the rules cache. It is prefixed so that it
+// never matches the hash of a user's token, which would otherwise serve the impersonated user's results
+// to whoever presents the user's serialized identity as a token.
+func impersonatedUserHash(userInfo UserInfo) (string, error) {
+	serialized, err := json.Marshal(userInfo)
+	if err != nil {
+		return "", err
+	}
+
+	return "impersonated:" + hashToken(string(serialized)), nil
+}
+
+// cacheKeyHash returns the key of the user in the rules cache, i.e. the hash of the user's token,
+// or of the impersonated user for an AccessReviewer returned by Impersonate
+func (r *AccessReviewer) cacheKeyHash(userToken string) string {
+	if r.impersonatedUser != nil {
+		return r.impersonatedUserHash
+	}
+
+	return hashToken(userToken)
+}
+
+// CheckSubjectAccess is the same as CheckAccess, but checks the access of the given user without the user's token,
+// by making a SubjectAccessReview with the identity of the k8s config or client set on the AccessReviewer,
+// which must be allowed to create SubjectAccessReviews.
+// An ErrMissingUser error is returned if the user's name is not set.
+//
+// - userInfo describes the user, e.g. as known from the authentication of a request or a background job
+//
+// - attributes describe the action, e.g. the "get" verb on a "managedclusters" resource named "devcluster1"
+// in the "cluster.open-cluster-management.io" group.
+func (r *AccessReviewer) CheckSubjectAccess(
+	ctx context.Context, userInfo UserInfo, attributes authorizationv1.ResourceAttributes,
+) (bool, string, error) {
+	if userInfo.Name == "" {
+		return false, "", ErrMissingUser
+	}
+
+	klog.V(2).Infof("CheckSubjectAccess for user: %s, attributes: %v", userInfo.Name, attributes)
+
+	hubKClient, err := r.getHubKubeClient()
+	if err != nil {
+		return false, "", err
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+			User:               userInfo.Name,
+			UID:                userInfo.UID,
+			Groups:             userInfo.Groups,
+		},
+	}
+
+	if userInfo.Extra != nil {
+		sar.Spec.Extra = make(map[string]authorizationv1.ExtraValue, len(userInfo.Extra))
+		for key, value := range userInfo.Extra {
+			sar.Spec.Extra[key] = value
+		}
+	}
+
+	response, err := hubKClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, sar, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", wrapAPIError(err)
+	}
+
+	sarStatus := response.Status
+
+	// Log the evaluation error, the decision is still returned as it may be based on other authorizers
+	if sarStatus.EvaluationError != "" {
+		klog.Infof("Encountered a SubjectAccessReview error for user %s and attributes %v: %v",
+			userInfo.Name, attributes, sarStatus.EvaluationError)
+	}
+
+	klog.V(2).Infof("Subject access review for user %s and attributes %v, allowed: %t, reason: %s",
+		userInfo.Name, attributes, sarStatus.Allowed, sarStatus.Reason)
+
+	return sarStatus.Allowed, sarStatus.Reason, nil
+}
@@ -0,0 +1,289 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestImpersonate(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, err := NewAccessReviewer(baseK8sConfig, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	testcases := []struct {
+		userInfo       UserInfo
+		expectedResult map[string][]string
+	}{
+		{
+			UserInfo{Name: "user-red", Groups: []string{"red-admins"}},
+			map[string][]string{"devcluster1": {"nsred1", "nsred2"}, "devcluster2": {"nsred1", "nsred2"}},
+		},
+		{ // the access is reviewed for the given groups, not the groups the user is known with
+			UserInfo{Name: "user-red", Groups: []string{"blue-admins"}},
+			map[string][]string{
+				"devcluster1": {"nsblue1", "nsblue2", "nsblue3"},
+				"devcluster2": {"nsblue1", "nsblue2", "nsblue3"},
+			},
+		},
+		{UserInfo{Name: "user-red"}, map[string][]string{}}, // no groups
+	}
+
+	for _, test := range testcases {
+		impersonatingEngine, err := rbacEngine.Impersonate(test.userInfo)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotResult, err := impersonatingEngine.GetMetricsAccess("")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessResults(test.expectedResult, gotResult) {
+			t.Fatalf("expected result for %v : %v , got  : %v", test.userInfo, test.expectedResult, gotResult)
+		}
+	}
+
+	allowed, _, err := rbacEngine.CheckSubjectAccess(context.TODO(),
+		UserInfo{Name: "someone", Groups: []string{"red-admins"}}, metricsAccessAttributes("devcluster1", "nsred1"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !allowed {
+		t.Fatalf("expected allowed : %t , got  : %t", true, allowed)
+	}
+}
+
+func TestImpersonateHeaders(t *testing.T) {
+	t.Parallel()
+
+	var headers http.Header
+
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers = req.Header.Clone()
+
+		review := &authorizationv1.SelfSubjectRulesReview{}
+		if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		review.Kind = "SelfSubjectRulesReview"
+		review.APIVersion = "authorization.k8s.io/v1"
+		review.Status.ResourceRules = redMetricsRules
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(review)
+	}))
+	t.Cleanup(fakeServer.Close)
+
+	rbacEngine, err := NewAccessReviewer(&rest.Config{Host: fakeServer.URL, BearerToken: "hub-token"}, nil,
+		WithStrictRules())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	impersonatingEngine, err := rbacEngine.Impersonate(UserInfo{
+		Name:   "user-red",
+		UID:    "1234",
+		Groups: []string{"red-admins", "system:authenticated"},
+		Extra:  map[string][]string{"scopes": {"user:full"}},
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !impersonatingEngine.strictRules {
+		t.Fatalf("expected the options of the AccessReviewer to be kept")
+	}
+
+	gotResult, err := impersonatingEngine.GetMetricsAccess("", "devcluster1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedResult := map[string][]string{"devcluster1": {"nsred1", "nsred2"}}
+	if !compareMetricsAccessResults(expectedResult, gotResult) {
+		t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+	}
+
+	// the review is made with the hub's credentials on behalf of the user
+	if headers.Get("Authorization") != "Bearer hub-token" || headers.Get("Impersonate-User") != "user-red" ||
+		headers.Get("Impersonate-Uid") != "1234" || headers.Get("Impersonate-Extra-Scopes") != "user:full" ||
+		!slices.Equal(headers.Values("Impersonate-Group"), []string{"red-admins", "system:authenticated"}) {
+		t.Fatalf("expected impersonation headers for user-red, got  : %v", headers)
+	}
+}
+
+func TestImpersonateSharedTransportAndCache(t *testing.T) {
+	t.Parallel()
+
+	fakeServer := newFakeRulesServer(t, map[string][]authorizationv1.ResourceRule{
+		"hub-token": redMetricsRules,
+	})
+
+	rbacEngine, err := NewAccessReviewer(&rest.Config{Host: fakeServer.URL, BearerToken: "hub-token"}, nil,
+		WithRulesCache(time.Minute, 0))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	getRequests := func() int {
+		fakeServer.lock.Lock()
+		defer fakeServer.lock.Unlock()
+
+		return len(fakeServer.tokens)
+	}
+
+	expectedResult := map[string][]string{"devcluster1": {"nsred1", "nsred2"}}
+
+	// the user is impersonated on every call, as when serving requests
+	for _, user := range []string{"user-red", "user-red", "user-blue", "user-blue"} {
+		impersonatingEngine, err := rbacEngine.Impersonate(UserInfo{Name: user})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotResult, err := impersonatingEngine.GetMetricsAccess("", "devcluster1")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessResults(expectedResult, gotResult) {
+			t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+		}
+	}
+
+	// the results are cached per impersonated user
+	if getRequests() != 2 {
+		t.Fatalf("expected num of requests : %d , got  : %d", 2, getRequests())
+	}
+
+	fakeServer.lock.Lock()
+	gotConns := fakeServer.conns
+	fakeServer.lock.Unlock()
+
+	// the connection is reused across the impersonated users as the clients share the transport
+	if gotConns != 1 {
+		t.Fatalf("expected num of connections : %d , got  : %d", 1, gotConns)
+	}
+
+	// the results of an impersonated user are never served for a token
+	serializedUser, _ := json.Marshal(UserInfo{Name: "user-red"})
+
+	gotResult, err := rbacEngine.GetMetricsAccess(string(serializedUser), "devcluster1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(gotResult["devcluster1"]) != 0 || getRequests() != 3 {
+		t.Fatalf("expected no access from a new review, got  : %v with %d requests", gotResult, getRequests())
+	}
+
+	impersonatingEngine, err := rbacEngine.Impersonate(UserInfo{Name: "user-red"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	impersonatingEngine.Invalidate("")
+
+	if _, err := impersonatingEngine.GetMetricsAccess("", "devcluster1"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if getRequests() != 4 {
+		t.Fatalf("expected num of requests : %d , got  : %d", 4, getRequests())
+	}
+}
+
+func TestImpersonationErrors(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, err := NewAccessReviewer(nil, fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err = rbacEngine.Impersonate(UserInfo{Name: "user-red"})
+	if !errors.Is(err, ErrImpersonationUnsupported) {
+		t.Fatalf("expected err: %s got err: %v", ErrImpersonationUnsupported, err)
+	}
+
+	_, _, err = rbacEngine.CheckSubjectAccess(context.TODO(), UserInfo{},
+		metricsAccessAttributes("devcluster1", "nsred1"))
+	if !errors.Is(err, ErrMissingUser) {
+		t.Fatalf("expected err: %s got err: %v", ErrMissingUser, err)
+	}
+
+	rbacEngine, err = NewAccessReviewer(&rest.Config{Host: "https://127.0.0.1:6443"}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err = rbacEngine.Impersonate(UserInfo{Groups: []string{"red-admins"}})
+	if !errors.Is(err, ErrMissingUser) {
+		t.Fatalf("expected err: %s got err: %v", ErrMissingUser, err)
+	}
+}
+
+func TestCheckSubjectAccess(t *testing.T) {
+	t.Parallel()
+
+	var gotSpec authorizationv1.SubjectAccessReviewSpec
+
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "subjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+			gotSpec = review.Spec
+			review.Status.Allowed = slices.Contains(review.Spec.Groups, "red-admins")
+			review.Status.Reason = "allowed by red-admins"
+
+			return true, review, nil
+		})
+
+	rbacEngine, err := NewAccessReviewer(nil, fakeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	userInfo := UserInfo{
+		Name:   "user-red",
+		UID:    "1234",
+		Groups: []string{"red-admins"},
+		Extra:  map[string][]string{"scopes": {"user:full"}},
+	}
+
+	allowed, reason, err := rbacEngine.CheckSubjectAccess(context.TODO(), userInfo,
+		metricsAccessAttributes("devcluster1", "nsred1"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !allowed || reason != "allowed by red-admins" {
+		t.Fatalf("expected allowed with reason : %s , got  : %t %s", "allowed by red-admins", allowed, reason)
+	}
+
+	if gotSpec.User != "user-red" || gotSpec.UID != "1234" || !slices.Equal(gotSpec.Groups, userInfo.Groups) ||
+		!slices.Equal(gotSpec.Extra["scopes"], authorizationv1.ExtraValue{"user:full"}) ||
+		gotSpec.ResourceAttributes.Verb != "metrics/nsred1" {
+		t.Fatalf("expected review for user-red, got  : %v", gotSpec)
+	}
+}
@@ -0,0 +1,245 @@
+package rbac
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	rbaclisters "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// RBACChange describes a change to a ClusterRole, Role, ClusterRoleBinding or RoleBinding observed by the
+// informers set with WithRBACInvalidation, and the cached results that were dropped for it.
+type RBACChange struct {
+	// Kind is the kind of the changed resource, e.g. "ClusterRole"
+	Kind string
+	// Namespace is the namespace of the changed resource, it is empty for cluster-scoped resources
+	Namespace string
+	// Name is the name of the changed resource
+	Name string
+	// AllNamespaces is true if the change may affect the rules in all namespaces, in which case all
+	// cached results are dropped
+	AllNamespaces bool
+	// Namespaces are the namespaces whose rules may be affected by the change, when AllNamespaces is false
+	Namespaces []string
+	// Invalidated is the number of cached results dropped, it is 0 if the rules cache is not enabled
+	Invalidated int
+}
+
+// WithRBACInvalidation drops the cached results of the rules cache as soon as the RBAC resources they may
+// depend on change, rather than when they expire. It registers handlers on the informers of the RBAC resources
+// of the given factory, which must be started by the caller, e.g. the factory shared with a LocalEvaluator.
+//
+// As the cached results are keyed by the users' tokens, the results are dropped per namespace rather than per
+// user: a change to a Role or RoleBinding drops the results in its namespace, a change to a ClusterRole drops the
+// results in the namespaces of the RoleBindings referencing it, and a change to a ClusterRoleBinding, or to a
+// ClusterRole referenced by one, drops all results.
+//
+// The changes are observed once the informers have synced, all the cached results are dropped at that time
+// as they may depend on changes made before.
+//
+// - factory is the shared informer factory, its client must be allowed to list and watch the RBAC resources
+// in all namespaces.
+//
+// - stopCh stops waiting for the informers to sync, it is usually the channel the factory is started with.
+//
+// - onChange is called for every change observed after the informers have synced, it may be nil.
+// It is called sequentially for the changes of each kind of resource and must not block.
+func WithRBACInvalidation(
+	factory informers.SharedInformerFactory, stopCh <-chan struct{}, onChange func(RBACChange),
+) Option {
+	return func(r *AccessReviewer) {
+		r.rbacInvalidation = &rbacInvalidation{factory: factory, stopCh: stopCh, onChange: onChange}
+	}
+}
+
+// rbacInvalidation holds the settings of WithRBACInvalidation, the invalidator is started by NewAccessReviewer
+// once all the options are applied
+type rbacInvalidation struct {
+	factory  informers.SharedInformerFactory
+	stopCh   <-chan struct{}
+	onChange func(RBACChange)
+}
+
+// startRBACInvalidator requests the informers of the RBAC resources, so that they are started with the factory,
+// and registers the invalidator's handlers on them once they have synced
+func (r *AccessReviewer) startRBACInvalidator() {
+	rbacInformers := r.rbacInvalidation.factory.Rbac().V1()
+	invalidator := &rbacInvalidator{
+		rulesCache:          r.rulesCache,
+		clusterRoleBindings: rbacInformers.ClusterRoleBindings().Lister(),
+		roleBindings:        rbacInformers.RoleBindings().Lister(),
+		onChange:            r.rbacInvalidation.onChange,
+		registered:          make(chan struct{}),
+	}
+
+	go invalidator.register(r.rbacInvalidation.stopCh, []cache.SharedIndexInformer{
+		rbacInformers.ClusterRoles().Informer(),
+		rbacInformers.Roles().Informer(),
+		rbacInformers.ClusterRoleBindings().Informer(),
+		rbacInformers.RoleBindings().Informer(),
+	})
+
+	r.rbacInvalidator = invalidator
+}
+
+// rbacInvalidator drops the cached results of the AccessReviewer on changes to the RBAC resources
+type rbacInvalidator struct {
+	// rulesCache is the rules cache of the AccessReviewer, it is nil if caching is not enabled
+	rulesCache          *rulesCache
+	clusterRoleBindings rbaclisters.ClusterRoleBindingLister
+	roleBindings        rbaclisters.RoleBindingLister
+	onChange            func(RBACChange)
+	// registered is closed once the event handlers have been added to all the informers
+	registered chan struct{}
+}
+
+// register adds the event handlers to the informers once they have synced, and drops the results cached
+// until then. The handlers are added after the informers have synced as their initial lists can't be told
+// apart from the resources added later by the handlers added before. No handler is added if stopCh is closed
+// before the informers have synced.
+func (i *rbacInvalidator) register(stopCh <-chan struct{}, informers []cache.SharedIndexInformer) {
+	for _, informer := range informers {
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			klog.Info("Stopped waiting for the RBAC informers to sync, the RBAC invalidation is disabled")
+
+			return
+		}
+
+		informer.AddEventHandler(i.eventHandler(informer))
+	}
+
+	if i.rulesCache != nil {
+		i.rulesCache.purge()
+	}
+
+	klog.V(2).Info("RBAC invalidation handlers registered")
+
+	close(i.registered)
+}
+
+// eventHandler returns the handler for the events of the synced informer, the Add events replayed by the
+// informer for the resources of its store and the resyncs are ignored
+func (i *rbacInvalidator) eventHandler(informer cache.SharedIndexInformer) cache.ResourceEventHandler {
+	// the resource versions of the resources in the store, by key, before the handler is added. The Add events
+	// of the resources added or updated since are not ignored, as their keys or resource versions differ.
+	storedVersions := map[string]string{}
+
+	for _, obj := range informer.GetStore().List() {
+		if key, version, ok := resourceVersion(obj); ok {
+			storedVersions[key] = version
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if key, version, ok := resourceVersion(obj); ok {
+				storedVersion, stored := storedVersions[key]
+				delete(storedVersions, key)
+
+				if stored && storedVersion == version {
+					return
+				}
+			}
+
+			i.handleChange(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, oldOK := oldObj.(metav1.Object)
+			newMeta, newOK := newObj.(metav1.Object)
+
+			if oldOK && newOK && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				return
+			}
+
+			i.handleChange(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			i.handleChange(obj)
+		},
+	}
+}
+
+// resourceVersion returns the key of the resource in the informer's store and its resource version
+func resourceVersion(obj interface{}) (string, string, bool) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return "", "", false
+	}
+
+	objMeta, ok := obj.(metav1.Object)
+	if !ok {
+		return "", "", false
+	}
+
+	return key, objMeta.GetResourceVersion(), true
+}
+
+// handleChange drops the cached results that may depend on the changed resource and notifies the change
+func (i *rbacInvalidator) handleChange(obj interface{}) {
+	change := RBACChange{}
+
+	switch obj := obj.(type) {
+	case *rbacv1.ClusterRole:
+		change.Kind, change.Name = "ClusterRole", obj.Name
+		change.Namespaces, change.AllNamespaces = i.getClusterRoleNamespaces(obj.Name)
+	case *rbacv1.Role:
+		change.Kind, change.Namespace, change.Name = "Role", obj.Namespace, obj.Name
+		change.Namespaces = []string{obj.Namespace}
+	case *rbacv1.ClusterRoleBinding:
+		change.Kind, change.Name = "ClusterRoleBinding", obj.Name
+		change.AllNamespaces = true
+	case *rbacv1.RoleBinding:
+		change.Kind, change.Namespace, change.Name = "RoleBinding", obj.Namespace, obj.Name
+		change.Namespaces = []string{obj.Namespace}
+	default:
+		return
+	}
+
+	if i.rulesCache != nil {
+		change.Invalidated = i.rulesCache.invalidateNamespaces(change.Namespaces, change.AllNamespaces)
+	}
+
+	klog.V(2).Infof("RBAC change of %s %s/%s, all namespaces: %t, namespaces: %v, invalidated %d cached results",
+		change.Kind, change.Namespace, change.Name, change.AllNamespaces, change.Namespaces, change.Invalidated)
+
+	if i.onChange != nil {
+		i.onChange(change)
+	}
+}
+
+// getClusterRoleNamespaces returns the namespaces of the RoleBindings referencing the named ClusterRole,
+// or true if it is referenced by a ClusterRoleBinding, and so may affect the rules in all namespaces
+func (i *rbacInvalidator) getClusterRoleNamespaces(name string) ([]string, bool) {
+	clusterRoleBindings, err := i.clusterRoleBindings.List(labels.Everything())
+	if err != nil {
+		return nil, true
+	}
+
+	for _, binding := range clusterRoleBindings {
+		if binding.RoleRef.Kind == "ClusterRole" && binding.RoleRef.Name == name {
+			return nil, true
+		}
+	}
+
+	roleBindings, err := i.roleBindings.List(labels.Everything())
+	if err != nil {
+		return nil, true
+	}
+
+	namespaces := []string{}
+
+	for _, binding := range roleBindings {
+		if binding.RoleRef.Kind == "ClusterRole" && binding.RoleRef.Name == name {
+			namespaces = addUniqueItems(namespaces, binding.Namespace)
+		}
+	}
+
+	return namespaces, false
+}
@@ -0,0 +1,353 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestRBACInvalidation(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewSimpleClientset()
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	factory := informers.NewSharedInformerFactory(fakeClient, 0)
+	changes := make(chan RBACChange, 10)
+
+	// the options may be passed in any order
+	rbacEngine, err := NewAccessReviewer(nil, fakeClient,
+		WithRBACInvalidation(factory, stopCh, func(change RBACChange) { changes <- change }),
+		WithRulesCache(time.Minute, 0))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	factory.Start(stopCh)
+
+	for informerType, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			t.Fatalf("expected the informer for %v to sync", informerType)
+		}
+	}
+
+	<-rbacEngine.rbacInvalidator.registered
+
+	fillCache := func() {
+		for _, namespace := range []string{"", "ns1", "ns2"} {
+			rbacEngine.rulesCache.add(hashToken("red-token"), namespace, redMetricsReview)
+			rbacEngine.rulesCache.add(hashToken("blue-token"), namespace, redMetricsReview)
+		}
+	}
+
+	// waitForChange returns the change of the named resource, skipping any other change
+	waitForChange := func(name string) RBACChange {
+		for {
+			select {
+			case change := <-changes:
+				if change.Name == name {
+					return change
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("expected a change of %s", name)
+			}
+		}
+	}
+
+	// cachedNamespaces returns the namespaces of the cached results of the red-token
+	cachedNamespaces := func() []string {
+		namespaces := []string{}
+
+		for _, namespace := range []string{"", "ns1", "ns2"} {
+			if _, ok := rbacEngine.rulesCache.get(hashToken("red-token"), namespace); ok {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+
+		return namespaces
+	}
+
+	rbacClient := fakeClient.RbacV1()
+	clusterRoleRef := rbacv1.RoleRef{Kind: "ClusterRole", Name: "view-configmaps"}
+	subjects := []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "red-admins"}}
+
+	testcases := []struct {
+		name               string
+		change             func() error
+		expectedChange     RBACChange
+		expectedNamespaces []string
+	}{
+		{ // a RoleBinding only affects the rules in its namespace
+			"view-configmaps-binding",
+			func() error {
+				_, err := rbacClient.RoleBindings("ns1").Create(context.TODO(), &rbacv1.RoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "view-configmaps-binding", Namespace: "ns1"},
+					Subjects:   subjects,
+					RoleRef:    clusterRoleRef,
+				}, metav1.CreateOptions{})
+
+				return err
+			},
+			RBACChange{Kind: "RoleBinding", Namespace: "ns1", Namespaces: []string{"ns1"}, Invalidated: 2},
+			[]string{"", "ns2"},
+		},
+		{ // a ClusterRole affects the rules in the namespaces of the RoleBindings referencing it
+			"view-configmaps",
+			func() error {
+				_, err := rbacClient.ClusterRoles().Create(context.TODO(), &rbacv1.ClusterRole{
+					ObjectMeta: metav1.ObjectMeta{Name: "view-configmaps"},
+				}, metav1.CreateOptions{})
+
+				return err
+			},
+			RBACChange{Kind: "ClusterRole", Namespaces: []string{"ns1"}, Invalidated: 2},
+			[]string{"", "ns2"},
+		},
+		{ // a Role only affects the rules in its namespace
+			"edit-configmaps",
+			func() error {
+				_, err := rbacClient.Roles("ns2").Create(context.TODO(), &rbacv1.Role{
+					ObjectMeta: metav1.ObjectMeta{Name: "edit-configmaps", Namespace: "ns2"},
+				}, metav1.CreateOptions{})
+
+				return err
+			},
+			RBACChange{Kind: "Role", Namespace: "ns2", Namespaces: []string{"ns2"}, Invalidated: 2},
+			[]string{"", "ns1"},
+		},
+		{ // a ClusterRoleBinding affects the rules in all namespaces
+			"view-configmaps-cluster-binding",
+			func() error {
+				_, err := rbacClient.ClusterRoleBindings().Create(context.TODO(), &rbacv1.ClusterRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "view-configmaps-cluster-binding"},
+					Subjects:   subjects,
+					RoleRef:    clusterRoleRef,
+				}, metav1.CreateOptions{})
+
+				return err
+			},
+			RBACChange{Kind: "ClusterRoleBinding", AllNamespaces: true, Invalidated: 6},
+			[]string{},
+		},
+		{ // once referenced by a ClusterRoleBinding, a ClusterRole affects the rules in all namespaces
+			"view-configmaps",
+			func() error {
+				return rbacClient.ClusterRoles().Delete(context.TODO(), "view-configmaps", metav1.DeleteOptions{})
+			},
+			RBACChange{Kind: "ClusterRole", AllNamespaces: true, Invalidated: 6},
+			[]string{},
+		},
+	}
+
+	for _, test := range testcases {
+		rbacEngine.Purge()
+		fillCache()
+
+		if err := test.change(); err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotChange := waitForChange(test.name)
+		test.expectedChange.Name = test.name
+
+		if gotChange.Kind != test.expectedChange.Kind || gotChange.Namespace != test.expectedChange.Namespace ||
+			gotChange.AllNamespaces != test.expectedChange.AllNamespaces ||
+			!slices.Equal(gotChange.Namespaces, test.expectedChange.Namespaces) ||
+			gotChange.Invalidated != test.expectedChange.Invalidated {
+			t.Fatalf("expected change : %+v , got  : %+v", test.expectedChange, gotChange)
+		}
+
+		if gotNamespaces := cachedNamespaces(); !slices.Equal(test.expectedNamespaces, gotNamespaces) {
+			t.Fatalf("%s: expected cached namespaces : %v , got  : %v",
+				test.name, test.expectedNamespaces, gotNamespaces)
+		}
+	}
+}
+
+func TestRBACInvalidationInitialList(t *testing.T) {
+	t.Parallel()
+
+	// the resources listed by the informers aren't changes
+	fakeClient := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "view-configmaps"}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "view-configmaps-cluster-binding"}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "edit-configmaps", Namespace: "ns1"}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "edit-configmaps-binding", Namespace: "ns1"}},
+	)
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	factory := informers.NewSharedInformerFactory(fakeClient, 0)
+	changes := make(chan RBACChange, 10)
+
+	rbacEngine, err := NewAccessReviewer(nil, fakeClient, WithRulesCache(time.Minute, 0),
+		WithRBACInvalidation(factory, stopCh, func(change RBACChange) { changes <- change }))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the results cached before the informers have synced are dropped
+	rbacEngine.rulesCache.add(hashToken("red-token"), "ns1", redMetricsReview)
+
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+	<-rbacEngine.rbacInvalidator.registered
+
+	if rbacEngine.rulesCache.len() != 0 {
+		t.Fatalf("expected num of cached results : %d , got  : %d", 0, rbacEngine.rulesCache.len())
+	}
+
+	_, err = fakeClient.RbacV1().Roles("ns2").Create(context.TODO(), &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "edit-configmaps", Namespace: "ns2"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the first change is the Role created after the informers have synced
+	select {
+	case change := <-changes:
+		if change.Kind != "Role" || change.Namespace != "ns2" {
+			t.Fatalf("expected change of Role ns2/edit-configmaps, got  : %+v", change)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected a change of Role ns2/edit-configmaps")
+	}
+}
+
+func TestRBACInvalidationDuringReview(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+
+	reviewStarted := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	// the fake clientset can't block a rules review without blocking the RBAC changes
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// only the first rules review is blocked
+		if atomic.AddInt32(&calls, 1) == 1 {
+			reviewStarted <- struct{}{}
+			<-release
+		}
+
+		review := &authorizationv1.SelfSubjectRulesReview{}
+		if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		review.Kind = "SelfSubjectRulesReview"
+		review.APIVersion = "authorization.k8s.io/v1"
+		review.Status.ResourceRules = redMetricsRules
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(review)
+	}))
+	t.Cleanup(fakeServer.Close)
+
+	fakeClient := fake.NewSimpleClientset()
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	factory := informers.NewSharedInformerFactory(fakeClient, 0)
+	changes := make(chan RBACChange, 10)
+
+	rbacEngine, err := NewAccessReviewer(&rest.Config{Host: fakeServer.URL}, nil, WithRulesCache(time.Minute, 0),
+		WithRBACInvalidation(factory, stopCh, func(change RBACChange) { changes <- change }))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+	<-rbacEngine.rbacInvalidator.registered
+
+	resultErr := make(chan error)
+
+	go func() {
+		_, err := rbacEngine.GetMetricsAccess("red-token")
+		resultErr <- err
+	}()
+
+	<-reviewStarted
+
+	// the RBAC resources change while the rules review is in-flight
+	_, err = fakeClient.RbacV1().ClusterRoleBindings().Create(context.TODO(), &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "view-metrics-binding"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view-metrics"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected a change of the ClusterRoleBinding")
+	}
+
+	close(release)
+
+	if err := <-resultErr; err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the rules reviewed before the change are not cached
+	if rbacEngine.rulesCache.len() != 0 {
+		t.Fatalf("expected num of cached results : %d , got  : %d", 0, rbacEngine.rulesCache.len())
+	}
+
+	// the next rules review is cached
+	for i := 0; i < 2; i++ {
+		if _, err := rbacEngine.GetMetricsAccess("red-token"); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	if numCalls := atomic.LoadInt32(&calls); numCalls != 2 {
+		t.Fatalf("expected num of rules reviews : %d , got  : %d", 2, numCalls)
+	}
+}
+
+func TestRBACInvalidationStopped(t *testing.T) {
+	t.Parallel()
+
+	// the factory is never started
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	stopCh := make(chan struct{})
+
+	rbacEngine, err := NewAccessReviewer(nil, fake.NewSimpleClientset(), WithRulesCache(time.Minute, 0),
+		WithRBACInvalidation(factory, stopCh, nil))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rbacEngine.rulesCache.add(hashToken("red-token"), "", redMetricsReview)
+	close(stopCh)
+
+	// no handler is registered once stopped, and the cached results are kept
+	select {
+	case <-rbacEngine.rbacInvalidator.registered:
+		t.Fatalf("expected no handler to be registered")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if rbacEngine.rulesCache.len() != 1 {
+		t.Fatalf("expected num of cached results : %d , got  : %d", 1, rbacEngine.rulesCache.len())
+	}
+}
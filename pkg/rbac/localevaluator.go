@@ -0,0 +1,328 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	rbaclisters "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// allAuthenticatedGroup is the group the k8s cluster adds to all authenticated users
+	allAuthenticatedGroup = "system:authenticated"
+	// anonymousUser is the name of the user of unauthenticated requests
+	anonymousUser = "system:anonymous"
+	// serviceAccountUsernamePrefix is the prefix of the user names of service accounts
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+	// subjectIndex is the name of the index of the ClusterRoleBindings and RoleBindings by subject
+	subjectIndex = "subject"
+)
+
+// LocalEvaluator evaluates the RBAC access of users in-process, from the ClusterRoles, Roles,
+// ClusterRoleBindings and RoleBindings watched by shared informers, without making any call to the k8s cluster
+// per request. It is intended for high request rates, e.g. in a metrics proxy, where a SelfSubjectRulesReview
+// per request doesn't scale. The rules of aggregated ClusterRoles are the ones set by the aggregation controller
+// of the k8s cluster.
+//
+// Unlike the AccessReviewer, only RBAC is evaluated, the access granted by other authorizers configured on the
+// k8s cluster, e.g. webhooks, is not taken into account.
+// It must be instantiated through the NewLocalEvaluator function.
+type LocalEvaluator struct {
+	clusterRoles rbaclisters.ClusterRoleLister
+	roles        rbaclisters.RoleLister
+	// clusterRoleBindings and roleBindings are the indexers of the bindings, indexed by subject
+	clusterRoleBindings cache.Indexer
+	roleBindings        cache.Indexer
+	hasSynced           []cache.InformerSynced
+}
+
+// NewLocalEvaluator creates an instance of LocalEvaluator, which registers the informers for the RBAC resources
+// on the given factory. The factory must be started after the LocalEvaluator is created, and HasSynced
+// should return true before evaluating the access, e.g. with cache.WaitForCacheSync.
+// The bindings are indexed by subject, so that only the bindings of the user are evaluated.
+//
+// - factory is the shared informer factory, its client must be allowed to list and watch the RBAC resources
+// in all namespaces.
+func NewLocalEvaluator(factory informers.SharedInformerFactory) *LocalEvaluator {
+	rbacInformers := factory.Rbac().V1()
+	clusterRoleBindingsInformer := rbacInformers.ClusterRoleBindings().Informer()
+	roleBindingsInformer := rbacInformers.RoleBindings().Informer()
+
+	addSubjectIndexer(clusterRoleBindingsInformer)
+	addSubjectIndexer(roleBindingsInformer)
+
+	return &LocalEvaluator{
+		clusterRoles:        rbacInformers.ClusterRoles().Lister(),
+		roles:               rbacInformers.Roles().Lister(),
+		clusterRoleBindings: clusterRoleBindingsInformer.GetIndexer(),
+		roleBindings:        roleBindingsInformer.GetIndexer(),
+		hasSynced: []cache.InformerSynced{
+			rbacInformers.ClusterRoles().Informer().HasSynced,
+			rbacInformers.Roles().Informer().HasSynced,
+			clusterRoleBindingsInformer.HasSynced,
+			roleBindingsInformer.HasSynced,
+		},
+	}
+}
+
+// addSubjectIndexer adds the index by subject to the informer of the bindings, unless it was added already,
+// e.g. by another LocalEvaluator sharing the factory
+func addSubjectIndexer(informer cache.SharedIndexInformer) {
+	if _, ok := informer.GetIndexer().GetIndexers()[subjectIndex]; ok {
+		return
+	}
+
+	if err := informer.AddIndexers(cache.Indexers{subjectIndex: indexBySubject}); err != nil {
+		klog.Infof("Failed to index the bindings by subject, was the factory started already? %v", err)
+	}
+}
+
+// HasSynced returns true once the informers of the RBAC resources have synced.
+func (e *LocalEvaluator) HasSynced() bool {
+	for _, hasSynced := range e.hasSynced {
+		if !hasSynced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetRulesReview returns the rules of the user in the given namespace, in the same form as the result
+// of a SelfSubjectRulesReview. If any role referenced by the user's bindings can't be found,
+// its EvaluationError is set and the rules of the other roles are returned.
+//
+// - userInfo describes the user. As for requests authenticated by the k8s cluster, the "system:authenticated"
+// group is added to the groups of any user other than "system:anonymous".
+//
+// - namespace is used for namespace-scoped resources, if left empty only the rules for
+// cluster-scoped resources are returned.
+func (e *LocalEvaluator) GetRulesReview(userInfo UserInfo, namespace string) (*RulesReviewResult, error) {
+	klog.V(2).Infof("LocalEvaluator GetRulesReview for user: %s, namespace: %s", userInfo.Name, namespace)
+
+	userInfo = withAuthenticatedGroup(userInfo)
+	rulesReview := &RulesReviewResult{}
+	evaluationErrors := []string{}
+
+	addRules := func(policyRules []rbacv1.PolicyRule, err error) {
+		if err != nil {
+			evaluationErrors = append(evaluationErrors, err.Error())
+		}
+
+		addPolicyRules(rulesReview, policyRules)
+	}
+
+	clusterRoleBindings, err := getBindingsBySubject(e.clusterRoleBindings, userInfo, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range clusterRoleBindings {
+		if binding, ok := obj.(*rbacv1.ClusterRoleBinding); ok {
+			addRules(e.getRoleRules(binding.RoleRef, ""))
+		}
+	}
+
+	if namespace != "" {
+		roleBindings, err := getBindingsBySubject(e.roleBindings, userInfo, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range roleBindings {
+			if binding, ok := obj.(*rbacv1.RoleBinding); ok {
+				addRules(e.getRoleRules(binding.RoleRef, namespace))
+			}
+		}
+	}
+
+	if len(evaluationErrors) != 0 {
+		rulesReview.EvaluationError = strings.Join(evaluationErrors, "; ")
+		klog.Infof("Encountered errors evaluating the rules of user %s in namespace %s: %s",
+			userInfo.Name, namespace, rulesReview.EvaluationError)
+	}
+
+	return rulesReview, nil
+}
+
+// GetResourceAccess is the same as the GetResourceAccess function, but evaluates the ACLs of the user locally.
+func (e *LocalEvaluator) GetResourceAccess(
+	userInfo UserInfo, gr schema.GroupResource, resourcenames []string, namespace string,
+) (ResourceAccess, error) {
+	rulesReview, err := e.GetRulesReview(userInfo, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return getResourceAccessFromRules(rulesReview.ResourceRules, gr, resourcenames), nil
+}
+
+// GetPrefixedAccess is the same as AccessReviewer.GetPrefixedAccess, but evaluates the access of the user locally.
+func (e *LocalEvaluator) GetPrefixedAccess(
+	userInfo UserInfo, cfg ACLConfig, names ...string,
+) (map[string][]string, error) {
+	rulesReview, err := e.GetRulesReview(userInfo, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return getPrefixedAccessFromRules(rulesReview.ResourceRules, cfg, names), nil
+}
+
+// GetMetricsAccess is the same as AccessReviewer.GetMetricsAccess, but evaluates the access of the user locally.
+func (e *LocalEvaluator) GetMetricsAccess(userInfo UserInfo, clusters ...string) (map[string][]string, error) {
+	return e.GetPrefixedAccess(userInfo, MetricsACLConfig, clusters...)
+}
+
+// getRoleRules returns the rules of the role referenced by a binding in the given namespace,
+// or by a ClusterRoleBinding if the namespace is empty
+func (e *LocalEvaluator) getRoleRules(roleRef rbacv1.RoleRef, namespace string) ([]rbacv1.PolicyRule, error) {
+	switch roleRef.Kind {
+	case "ClusterRole":
+		return e.getClusterRoleRules(roleRef.Name)
+	case "Role":
+		if namespace == "" {
+			return nil, fmt.Errorf("a ClusterRoleBinding can't reference the Role %s", roleRef.Name)
+		}
+
+		role, err := e.roles.Roles(namespace).Get(roleRef.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		return role.Rules, nil
+	default:
+		return nil, fmt.Errorf("unsupported role reference kind %s", roleRef.Kind)
+	}
+}
+
+// getClusterRoleRules returns the rules of the named ClusterRole. The rules of aggregated ClusterRoles are
+// set by the aggregation controller of the k8s cluster, so they are used as is, like the k8s RBAC authorizer does.
+func (e *LocalEvaluator) getClusterRoleRules(name string) ([]rbacv1.PolicyRule, error) {
+	clusterRole, err := e.clusterRoles.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return clusterRole.Rules, nil
+}
+
+// addPolicyRules adds the RBAC policy rules to the result, as ResourceRules and NonResourceRules.
+// The rules are copied, as the policy rules are shared with the informers' caches.
+func addPolicyRules(rulesReview *RulesReviewResult, policyRules []rbacv1.PolicyRule) {
+	for i := range policyRules {
+		policyRule := policyRules[i].DeepCopy()
+
+		if len(policyRule.NonResourceURLs) != 0 {
+			rulesReview.NonResourceRules = append(rulesReview.NonResourceRules, authorizationv1.NonResourceRule{
+				Verbs:           policyRule.Verbs,
+				NonResourceURLs: policyRule.NonResourceURLs,
+			})
+
+			continue
+		}
+
+		rulesReview.ResourceRules = append(rulesReview.ResourceRules, authorizationv1.ResourceRule{
+			Verbs:         policyRule.Verbs,
+			APIGroups:     policyRule.APIGroups,
+			Resources:     policyRule.Resources,
+			ResourceNames: policyRule.ResourceNames,
+		})
+	}
+}
+
+// getBindingsBySubject returns the bindings of the indexer in the given namespace, or the ClusterRoleBindings
+// if the namespace is empty, with the user or one of the user's groups as subject
+func getBindingsBySubject(indexer cache.Indexer, userInfo UserInfo, namespace string) ([]interface{}, error) {
+	keys := make([]string, 0, len(userInfo.Groups)+1)
+	keys = append(keys, subjectIndexKey(namespace, rbacv1.UserKind, userInfo.Name))
+
+	for _, group := range userInfo.Groups {
+		keys = append(keys, subjectIndexKey(namespace, rbacv1.GroupKind, group))
+	}
+
+	bindings := []interface{}{}
+	found := map[string]bool{}
+
+	for _, key := range keys {
+		objs, err := indexer.ByIndex(subjectIndex, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// a binding may have many subjects of the user
+		for _, obj := range objs {
+			bindingKey, err := cache.MetaNamespaceKeyFunc(obj)
+			if err != nil || found[bindingKey] {
+				continue
+			}
+
+			found[bindingKey] = true
+			bindings = append(bindings, obj)
+		}
+	}
+
+	return bindings, nil
+}
+
+// indexBySubject is the index function of the ClusterRoleBindings and RoleBindings by subject
+func indexBySubject(obj interface{}) ([]string, error) {
+	switch binding := obj.(type) {
+	case *rbacv1.ClusterRoleBinding:
+		return subjectIndexKeys(binding.Subjects, ""), nil
+	case *rbacv1.RoleBinding:
+		return subjectIndexKeys(binding.Subjects, binding.Namespace), nil
+	default:
+		return nil, nil
+	}
+}
+
+// subjectIndexKeys returns the index keys of the subjects of a binding in the given namespace, or of a
+// ClusterRoleBinding if the namespace is empty. Service accounts are indexed by their user names.
+func subjectIndexKeys(subjects []rbacv1.Subject, namespace string) []string {
+	keys := make([]string, 0, len(subjects))
+
+	for _, subject := range subjects {
+		switch subject.Kind {
+		case rbacv1.UserKind, rbacv1.GroupKind:
+			keys = append(keys, subjectIndexKey(namespace, subject.Kind, subject.Name))
+		case rbacv1.ServiceAccountKind:
+			// the namespace of the binding is the default namespace of service accounts
+			saNamespace := namespace
+			if subject.Namespace != "" {
+				saNamespace = subject.Namespace
+			}
+
+			if saNamespace != "" {
+				keys = append(keys, subjectIndexKey(namespace, rbacv1.UserKind,
+					serviceAccountUsernamePrefix+saNamespace+":"+subject.Name))
+			}
+		}
+	}
+
+	return keys
+}
+
+// subjectIndexKey returns the index key of a user or group subject of the bindings in the given namespace
+func subjectIndexKey(namespace string, kind string, name string) string {
+	return namespace + "/" + kind + ":" + name
+}
+
+// withAuthenticatedGroup returns the user with the "system:authenticated" group added, unless the user
+// is anonymous, as done by the k8s cluster for authenticated requests
+func withAuthenticatedGroup(userInfo UserInfo) UserInfo {
+	if userInfo.Name == anonymousUser || slices.Contains(userInfo.Groups, allAuthenticatedGroup) {
+		return userInfo
+	}
+
+	userInfo.Groups = append(append([]string{}, userInfo.Groups...), allAuthenticatedGroup)
+
+	return userInfo
+}
@@ -0,0 +1,287 @@
+package rbac
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newSyncedLocalEvaluator returns a LocalEvaluator with the informers of the given client started and synced
+func newSyncedLocalEvaluator(t *testing.T, kclient kubernetes.Interface) *LocalEvaluator {
+	t.Helper()
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	factory := informers.NewSharedInformerFactory(kclient, 0)
+	localEvaluator := NewLocalEvaluator(factory)
+	factory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, localEvaluator.HasSynced) {
+		t.Fatalf("expected the informers to sync")
+	}
+
+	return localEvaluator
+}
+
+func TestLocalEvaluatorParity(t *testing.T) {
+	t.Parallel()
+
+	localEvaluator := newSyncedLocalEvaluator(t, baseK8sClient)
+
+	users := map[string]struct {
+		KubeClient kubernetes.Interface
+		Groups     []string
+	}{
+		"cluster-admin": {baseK8sClient, []string{"system:masters"}},
+	}
+	for username, testUser := range testUsers {
+		users[username] = testUser
+	}
+
+	clustersInputs := [][]string{{}, {"devcluster1"}, {"devcluster1", "devcluster2", "blah"}}
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+
+	for username, testUser := range users {
+		userInfo := UserInfo{Name: username, Groups: testUser.Groups}
+
+		rbacEngine, err := NewAccessReviewer(nil, testUser.KubeClient)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		for _, cfg := range []ACLConfig{MetricsACLConfig, LogsACLConfig} {
+			for _, clusters := range clustersInputs {
+				expectedResult, err := rbacEngine.GetPrefixedAccess(context.TODO(), "", cfg, clusters...)
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+
+				gotResult, err := localEvaluator.GetPrefixedAccess(userInfo, cfg, clusters...)
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+
+				if !compareMetricsAccessResults(expectedResult, gotResult) ||
+					!compareMetricsAccessResults(gotResult, expectedResult) {
+					t.Fatalf("user %s, %s for %v: expected result : %v , got  : %v",
+						username, cfg.verb, clusters, expectedResult, gotResult)
+				}
+			}
+		}
+
+		for _, namespace := range []string{"", "default"} {
+			expectedAccess, err := GetResourceAccess(testUser.KubeClient, configMaps, []string{"cm1"}, namespace)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			gotAccess, err := localEvaluator.GetResourceAccess(userInfo, configMaps, []string{"cm1"}, namespace)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			if !compareMetricsAccessResults(expectedAccess, gotAccess) ||
+				!compareMetricsAccessResults(gotAccess, expectedAccess) {
+				t.Fatalf("user %s, namespace %s: expected access : %v , got  : %v",
+					username, namespace, expectedAccess, gotAccess)
+			}
+		}
+	}
+}
+
+func TestLocalEvaluator(t *testing.T) {
+	t.Parallel()
+
+	metricsAggregationLabels := map[string]string{"rbac.example.com/aggregate-to-metrics": "true"}
+	redMetricsRule := rbacv1.PolicyRule{
+		APIGroups:     []string{"cluster.open-cluster-management.io"},
+		Resources:     []string{"managedclusters"},
+		ResourceNames: []string{"devcluster1"},
+		Verbs:         []string{"metrics/nsred1"},
+	}
+	objects := []runtime.Object{
+		// the rules of the aggregated ClusterRole are set by the aggregation controller,
+		// view-blue-metrics isn't aggregated yet
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "view-metrics"},
+			AggregationRule: &rbacv1.AggregationRule{
+				ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: metricsAggregationLabels}},
+			},
+			Rules: []rbacv1.PolicyRule{redMetricsRule},
+		},
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "view-red-metrics", Labels: metricsAggregationLabels},
+			Rules:      []rbacv1.PolicyRule{redMetricsRule},
+		},
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "view-blue-metrics", Labels: metricsAggregationLabels},
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups:     []string{"cluster.open-cluster-management.io"},
+				Resources:     []string{"managedclusters"},
+				ResourceNames: []string{"devcluster2"},
+				Verbs:         []string{"metrics/nsblue1"},
+			}},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "view-metrics-binding"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "metrics-viewers"}},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view-metrics"},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "missing-role-binding"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "metrics-viewers"}},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "missing-role"},
+		},
+		&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit-configmaps", Namespace: "ns1"},
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "update"},
+			}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit-configmaps-binding", Namespace: "ns1"},
+			Subjects: []rbacv1.Subject{
+				{Kind: rbacv1.ServiceAccountKind, Name: "editor"},
+				{Kind: rbacv1.UserKind, Name: "user-editor"},
+				{Kind: rbacv1.GroupKind, Name: "configmaps-editors"},
+			},
+			RoleRef: rbacv1.RoleRef{Kind: "Role", Name: "edit-configmaps"},
+		},
+	}
+
+	localEvaluator := newSyncedLocalEvaluator(t, fake.NewSimpleClientset(objects...))
+
+	// the rules of the aggregated ClusterRole are the ones set by the aggregation controller
+	gotResult, err := localEvaluator.GetMetricsAccess(UserInfo{Name: "user-red", Groups: []string{"metrics-viewers"}})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedResult := map[string][]string{"devcluster1": {"nsred1"}}
+	if !compareMetricsAccessResults(expectedResult, gotResult) || len(gotResult["devcluster1"]) != 1 ||
+		len(gotResult["devcluster2"]) != 0 {
+		t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+	}
+
+	rulesReview, err := localEvaluator.GetRulesReview(
+		UserInfo{Name: "user-red", Groups: []string{"metrics-viewers"}}, "")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !strings.Contains(rulesReview.EvaluationError, "missing-role") {
+		t.Fatalf("expected evaluation error for missing-role, got  : %s", rulesReview.EvaluationError)
+	}
+
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+
+	testcases := []struct {
+		userInfo        UserInfo
+		namespace       string
+		expectedAllowed bool
+	}{
+		{UserInfo{Name: "system:serviceaccount:ns1:editor"}, "ns1", true},
+		{UserInfo{Name: "system:serviceaccount:ns2:editor"}, "ns1", false},
+		{UserInfo{Name: "user-editor"}, "ns1", true},
+		{UserInfo{Name: "user-editor"}, "ns2", false},
+		{UserInfo{Name: "user-editor"}, "", false},
+		{UserInfo{Name: "user-red", Groups: []string{"metrics-viewers"}}, "ns1", false},
+	}
+
+	for _, test := range testcases {
+		access, err := localEvaluator.GetResourceAccess(test.userInfo, configMaps, nil, test.namespace)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if gotAllowed := access.AllowsAll("update"); gotAllowed != test.expectedAllowed {
+			t.Fatalf("expected update allowed for %s in %s : %t , got  : %t",
+				test.userInfo.Name, test.namespace, test.expectedAllowed, gotAllowed)
+		}
+	}
+	// the rules of a binding are added once when many of its subjects are the user
+	rulesReview, err = localEvaluator.GetRulesReview(
+		UserInfo{Name: "user-editor", Groups: []string{"configmaps-editors"}}, "ns1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(rulesReview.ResourceRules) != 1 {
+		t.Fatalf("expected num of rules : %d , got  : %d", 1, len(rulesReview.ResourceRules))
+	}
+
+	// the rules can be modified by the caller without modifying the informers' caches
+
+	rulesReview.ResourceRules[0].Verbs[0] = "delete"
+
+	role, err := localEvaluator.roles.Roles("ns1").Get("edit-configmaps")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if role.Rules[0].Verbs[0] != "get" {
+		t.Fatalf("expected verbs of the cached Role : %v , got  : %v", []string{"get", "update"}, role.Rules[0].Verbs)
+	}
+}
+
+func TestIndexBySubject(t *testing.T) {
+	t.Parallel()
+
+	subjects := []rbacv1.Subject{
+		{Kind: rbacv1.UserKind, Name: "user-red"},
+		{Kind: rbacv1.GroupKind, Name: "red-admins"},
+		{Kind: rbacv1.ServiceAccountKind, Name: "editor"},
+		{Kind: rbacv1.ServiceAccountKind, Name: "viewer", Namespace: "ns2"},
+	}
+
+	testcases := []struct {
+		binding      interface{}
+		expectedKeys []string
+	}{
+		{
+			&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "rb", Namespace: "ns1"}, Subjects: subjects},
+			[]string{
+				"ns1/User:user-red",
+				"ns1/Group:red-admins",
+				"ns1/User:system:serviceaccount:ns1:editor",
+				"ns1/User:system:serviceaccount:ns2:viewer",
+			},
+		},
+		{ // the service accounts of a ClusterRoleBinding must have a namespace
+			&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "crb"}, Subjects: subjects},
+			[]string{"/User:user-red", "/Group:red-admins", "/User:system:serviceaccount:ns2:viewer"},
+		},
+		{&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "role", Namespace: "ns1"}}, nil},
+	}
+
+	for _, test := range testcases {
+		gotKeys, err := indexBySubject(test.binding)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !reflect.DeepEqual(test.expectedKeys, gotKeys) {
+			t.Fatalf("expected keys : %v , got  : %v", test.expectedKeys, gotKeys)
+		}
+	}
+
+	// the index is added once when the informers are shared by many LocalEvaluators
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	NewLocalEvaluator(factory)
+	localEvaluator := NewLocalEvaluator(factory)
+
+	if _, ok := localEvaluator.roleBindings.GetIndexers()[subjectIndex]; !ok {
+		t.Fatalf("expected the RoleBindings to be indexed by subject")
+	}
+}
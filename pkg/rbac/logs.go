@@ -0,0 +1,45 @@
+package rbac
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// LogsACLConfig is an instance of ACLConfig and holds configuration for accessing logs
+// gathered from ManagedClusters, i.e. the "logs/<namespace>" verbs on the "managedclusters" resource.
+var LogsACLConfig = ACLConfig{
+	groupRes: schema.GroupResource{
+		Group:    "cluster.open-cluster-management.io",
+		Resource: "managedclusters",
+	},
+	verb: "logs/",
+}
+
+// GetLogsAccess returns a map of managed clusters and the namespaces on them for which the user has access
+// to view logs, with the same semantics as GetMetricsAccess for the "logs/<namespace>" verbs.
+// If the user has access to all namespaces on a cluster, "*" is returned as the namespace, and access
+// to all clusters is returned under the "*" cluster.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - clusters are the names of the managed clusters for which allowed logs access is returned.
+// If no clusters are specified, then logs access is returned for all "allowed" managed clusters.
+// A requested cluster without logs access is returned with an empty list of namespaces.
+func (r *AccessReviewer) GetLogsAccess(userToken string, clusters ...string) (map[string][]string, error) {
+	return r.GetLogsAccessWithContext(context.TODO(), userToken, clusters...)
+}
+
+// GetLogsAccessWithContext is the same as GetLogsAccess, but the given context is used for the calls
+// made to the k8s cluster, so cancellation, deadlines and request-scoped values are propagated to them.
+func (r *AccessReviewer) GetLogsAccessWithContext(
+	ctx context.Context, userToken string, clusters ...string,
+) (map[string][]string, error) {
+	klog.V(2).Infof("GetLogsAccess for clusters: %v", clusters)
+
+	return r.audited(ctx, "GetLogsAccess", userToken, clusters,
+		func(ctx context.Context) (map[string][]string, error) {
+			return r.GetPrefixedAccess(ctx, userToken, LogsACLConfig, clusters...)
+		})
+}
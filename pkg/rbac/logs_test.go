@@ -0,0 +1,98 @@
+package rbac
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+func TestGetLogsAccess(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		username       string
+		kubeClient     kubernetes.Interface
+		inputClusters  []string
+		expectedResult map[string][]string
+	}{
+		{ // user-log-viewer has access to blue namespaces 1 & 2 on devcluster1 and kube-system on all clusters
+			"user-log-viewer",
+			testUsers["user-log-viewer"].KubeClient,
+			[]string{}, // get logs access for all managed clusters
+			map[string][]string{
+				"devcluster1": {"nsblue1", "nsblue2"},
+				"*":           {"kube-system"},
+			},
+		},
+		{
+			"user-log-viewer",
+			testUsers["user-log-viewer"].KubeClient,
+			[]string{"devcluster1"}, // get logs access for a single specific managedcluster
+			map[string][]string{
+				"devcluster1": {"nsblue1", "nsblue2", "kube-system"},
+			},
+		},
+		{
+			"user-log-viewer",
+			testUsers["user-log-viewer"].KubeClient,
+			[]string{"devcluster2"}, // only the access on all clusters applies
+			map[string][]string{
+				"devcluster2": {"kube-system"},
+			},
+		},
+		{ // user-red has metrics access only
+			"user-red",
+			testUsers["user-red"].KubeClient,
+			[]string{},
+			map[string][]string{},
+		},
+		{
+			"user-red",
+			testUsers["user-red"].KubeClient,
+			[]string{"devcluster1"}, // requested clusters without logs access have an empty list
+			map[string][]string{
+				"devcluster1": {},
+			},
+		},
+		{ // cluster-admin should have rule *,*,*
+			"cluster-admin",
+			baseK8sClient,
+			[]string{},
+			map[string][]string{
+				"*": {"*"},
+			},
+		},
+	}
+
+	for _, test := range testcases {
+		rbacEngine, err := NewAccessReviewer(nil, test.kubeClient)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotResult, err := rbacEngine.GetLogsAccess("", test.inputClusters...)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessResults(test.expectedResult, gotResult) {
+			t.Fatalf("expected result for %s : %v , got  : %v", test.username, test.expectedResult, gotResult)
+		}
+	}
+
+	// the logs verbs are not returned as metrics access
+	rbacEngine, err := NewAccessReviewer(nil, testUsers["user-log-viewer"].KubeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	gotResult, err := rbacEngine.GetMetricsAccess("")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedResult := map[string][]string{"devcluster1": {"nsblue1"}}
+	if !compareMetricsAccessResults(expectedResult, gotResult) {
+		t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+	}
+}
@@ -0,0 +1,229 @@
+package rbac
+
+import (
+	"context"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/klog"
+)
+
+// AccessSource describes how an entry in the access results was determined.
+type AccessSource string
+
+const (
+	// AccessInferred is set on entries derived from the user's rules returned by a SelfSubjectRulesReview.
+	// The rules are only complete for the RBAC authorizer, so access granted by other authorizers is missed.
+	AccessInferred AccessSource = "inferred"
+	// AccessVerified is set on entries confirmed with a SelfSubjectAccessReview, which is authoritative
+	// for all the authorizers configured on the k8s cluster.
+	AccessVerified AccessSource = "verified"
+)
+
+// MetricsAccessDetails maps the managed clusters to the namespaces on them for which the user has access
+// to view metrics, along with how the access to each namespace was determined.
+type MetricsAccessDetails map[string]map[string]AccessSource
+
+// MetricsAccessVerification configures how the metrics access derived from the user's rules is checked
+// with SelfSubjectAccessReviews for the "metrics/<namespace>" verb on the managed cluster.
+type MetricsAccessVerification struct {
+	// Verify checks each of the clusters and namespaces derived from the user's rules. Namespaces that
+	// are confirmed are flagged as verified, while namespaces that are denied are dropped from the results.
+	Verify bool
+	// Namespaces are checked on each of the requested clusters to supplement the access derived from
+	// the user's rules, e.g. when access is granted by a webhook authorizer. Namespaces that are allowed
+	// are added to the results as verified. If no clusters are requested, they are checked on the clusters
+	// in the rules-derived results.
+	Namespaces []string
+}
+
+// WithMetricsAccessVerification enables checking the metrics access derived from the user's rules
+// with SelfSubjectAccessReviews, as configured by the given verification. It applies to both
+// GetMetricsAccess and GetMetricsAccessDetails.
+func WithMetricsAccessVerification(verification MetricsAccessVerification) Option {
+	return func(r *AccessReviewer) {
+		r.metricsVerification = &verification
+	}
+}
+
+// metricsAccessCheck is a managed cluster and namespace to check the metrics access for
+type metricsAccessCheck struct {
+	cluster   string
+	namespace string
+}
+
+// GetMetricsAccessDetails is the same as GetMetricsAccessWithContext, but it also returns how the access to each
+// namespace was determined. Without WithMetricsAccessVerification set on the AccessReviewer, all entries
+// are inferred from the user's rules.
+func (r *AccessReviewer) GetMetricsAccessDetails(
+	ctx context.Context, userToken string, clusters ...string,
+) (MetricsAccessDetails, error) {
+	klog.V(2).Infof("GetMetricsAccessDetails for clusters: %v", clusters)
+
+	var metricsAccessDetails MetricsAccessDetails
+
+	_, err := r.audited(ctx, "GetMetricsAccessDetails", userToken, clusters,
+		func(ctx context.Context) (map[string][]string, error) {
+			var err error
+
+			metricsAccessDetails, err = r.getMetricsAccessDetails(ctx, userToken, clusters)
+			if err != nil {
+				return nil, err
+			}
+
+			return metricsAccessDetails.namespaces(), nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return metricsAccessDetails, nil
+}
+
+// getMetricsAccessDetails returns the user's access to metrics with how the access to each namespace
+// was determined, in the same form as returned by GetMetricsAccessDetails.
+func (r *AccessReviewer) getMetricsAccessDetails(
+	ctx context.Context, userToken string, clusters []string,
+) (MetricsAccessDetails, error) {
+	inferredAccess, err := r.getInferredMetricsAccess(ctx, userToken, clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsAccessDetails := make(MetricsAccessDetails, len(inferredAccess))
+
+	for cluster, namespaces := range inferredAccess {
+		metricsAccessDetails[cluster] = make(map[string]AccessSource, len(namespaces))
+
+		for _, namespace := range namespaces {
+			metricsAccessDetails[cluster][namespace] = AccessInferred
+		}
+	}
+
+	if r.metricsVerification == nil {
+		return metricsAccessDetails, nil
+	}
+
+	checks := r.getMetricsAccessChecks(metricsAccessDetails, clusters)
+	if len(checks) == 0 {
+		return metricsAccessDetails, nil
+	}
+
+	attributes := make([]authorizationv1.ResourceAttributes, len(checks))
+	for i, check := range checks {
+		attributes[i] = metricsAccessAttributes(check.cluster, check.namespace)
+	}
+
+	decisions, err := r.CheckAccessMany(ctx, userToken, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	// the checks can't be trusted if the context is done
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for i, decision := range decisions {
+		check := checks[i]
+
+		switch {
+		case decision.Err != nil:
+			// keep the inferred access, if any, as the access could not be checked
+			klog.Infof("Failed to verify metrics access to namespace %s on cluster %s: %v",
+				check.namespace, check.cluster, decision.Err)
+		case decision.Allowed:
+			if metricsAccessDetails[check.cluster] == nil {
+				metricsAccessDetails[check.cluster] = make(map[string]AccessSource)
+			}
+
+			metricsAccessDetails[check.cluster][check.namespace] = AccessVerified
+		default:
+			delete(metricsAccessDetails[check.cluster], check.namespace)
+		}
+	}
+
+	// drop the clusters left without namespaces, unless they were requested
+	for cluster, namespaces := range metricsAccessDetails {
+		if len(namespaces) == 0 && !slices.Contains(clusters, cluster) {
+			delete(metricsAccessDetails, cluster)
+		}
+	}
+
+	klog.V(2).Infof("metricsAccessDetails is %v", metricsAccessDetails)
+
+	return metricsAccessDetails, nil
+}
+
+// getMetricsAccessChecks returns the clusters and namespaces to check as configured by the metrics verification
+func (r *AccessReviewer) getMetricsAccessChecks(
+	metricsAccessDetails MetricsAccessDetails, clusters []string,
+) []metricsAccessCheck {
+	checks := []metricsAccessCheck{}
+
+	if r.metricsVerification.Verify {
+		for cluster, namespaces := range metricsAccessDetails {
+			for namespace := range namespaces {
+				checks = append(checks, metricsAccessCheck{cluster: cluster, namespace: namespace})
+			}
+		}
+	}
+
+	if len(r.metricsVerification.Namespaces) == 0 {
+		return checks
+	}
+
+	supplementClusters := clusters
+	if len(supplementClusters) == 0 {
+		supplementClusters = make([]string, 0, len(metricsAccessDetails))
+		for cluster := range metricsAccessDetails {
+			supplementClusters = append(supplementClusters, cluster)
+		}
+	}
+
+	for _, cluster := range supplementClusters {
+		// there is nothing to supplement if the user has access to all namespaces
+		if _, ok := metricsAccessDetails[cluster]["*"]; ok {
+			continue
+		}
+
+		for _, namespace := range r.metricsVerification.Namespaces {
+			if _, ok := metricsAccessDetails[cluster][namespace]; !ok {
+				checks = append(checks, metricsAccessCheck{cluster: cluster, namespace: namespace})
+			}
+		}
+	}
+
+	return checks
+}
+
+// namespaces returns the managed clusters and their namespaces, in the form returned by GetMetricsAccess
+func (d MetricsAccessDetails) namespaces() map[string][]string {
+	metricsAccessResults := make(map[string][]string, len(d))
+
+	for cluster, namespaces := range d {
+		metricsAccessResults[cluster] = make([]string, 0, len(namespaces))
+
+		for namespace := range namespaces {
+			metricsAccessResults[cluster] = append(metricsAccessResults[cluster], namespace)
+		}
+	}
+
+	return metricsAccessResults
+}
+
+// metricsAccessAttributes returns the resource attributes to check the metrics access to the namespace
+// on the managed cluster. A "*" cluster or namespace stands for all of them.
+func metricsAccessAttributes(cluster string, namespace string) authorizationv1.ResourceAttributes {
+	// an empty name checks the access to all resources of the type
+	if cluster == "*" {
+		cluster = ""
+	}
+
+	return authorizationv1.ResourceAttributes{
+		Group:    MetricsACLConfig.groupRes.Group,
+		Resource: MetricsACLConfig.groupRes.Resource,
+		Name:     cluster,
+		Verb:     MetricsACLConfig.verb + namespace,
+	}
+}
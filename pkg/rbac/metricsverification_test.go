@@ -0,0 +1,173 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeAuthorizerClient returns a fake k8s client that responds to SelfSubjectRulesReviews with the given rules
+// and to SelfSubjectAccessReviews with the decision of the given authorize function, e.g. to mimic
+// a webhook authorizer whose decisions are not reflected in the rules
+func newFakeAuthorizerClient(
+	resourceRules []authorizationv1.ResourceRule, authorize func(authorizationv1.ResourceAttributes) bool,
+) kubernetes.Interface {
+	var calls int32
+
+	fakeClient := newFakeRulesClient(resourceRules, &calls).(*fake.Clientset)
+	fakeClient.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = authorize(*review.Spec.ResourceAttributes)
+
+			return true, review, nil
+		})
+
+	return fakeClient
+}
+
+func TestGetMetricsAccessDetails(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		username       string
+		kubeClient     kubernetes.Interface
+		verification   *MetricsAccessVerification
+		inputClusters  []string
+		expectedResult MetricsAccessDetails
+	}{
+		{ // without verification all entries are inferred
+			"user-red",
+			testUsers["user-red"].KubeClient,
+			nil,
+			[]string{"devcluster1"},
+			MetricsAccessDetails{"devcluster1": {"nsred1": AccessInferred, "nsred2": AccessInferred}},
+		},
+		{ // RBAC grants are confirmed by the access reviews
+			"user-red",
+			testUsers["user-red"].KubeClient,
+			&MetricsAccessVerification{Verify: true},
+			[]string{},
+			MetricsAccessDetails{
+				"devcluster1": {"nsred1": AccessVerified, "nsred2": AccessVerified},
+				"devcluster2": {"nsred1": AccessVerified, "nsred2": AccessVerified},
+			},
+		},
+		{ // access to all clusters is verified
+			"user-sysadmin",
+			testUsers["user-sysadmin"].KubeClient,
+			&MetricsAccessVerification{Verify: true},
+			[]string{},
+			MetricsAccessDetails{"*": {"kube-system": AccessVerified}},
+		},
+		{ // supplemented namespaces are only added if allowed
+			"user-blue",
+			testUsers["user-blue"].KubeClient,
+			&MetricsAccessVerification{Namespaces: []string{"nsblue1", "nsred1"}},
+			[]string{"devcluster1", "devcluster3"},
+			MetricsAccessDetails{
+				"devcluster1": {"nsblue1": AccessInferred, "nsblue2": AccessInferred, "nsblue3": AccessInferred},
+				"devcluster3": {},
+			},
+		},
+	}
+
+	for _, test := range testcases {
+		opts := []Option{}
+		if test.verification != nil {
+			opts = append(opts, WithMetricsAccessVerification(*test.verification))
+		}
+
+		rbacEngine, err := NewAccessReviewer(nil, test.kubeClient, opts...)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotResult, err := rbacEngine.GetMetricsAccessDetails(context.TODO(), "", test.inputClusters...)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessDetails(test.expectedResult, gotResult) {
+			t.Fatalf("user %s: expected result : %v , got  : %v", test.username, test.expectedResult, gotResult)
+		}
+	}
+}
+
+func TestGetMetricsAccessWithVerification(t *testing.T) {
+	t.Parallel()
+
+	// the webhook authorizer allows nswebhook on devcluster1 and denies nsred2 on devcluster2,
+	// neither of which is reflected in the rules
+	authorize := func(attributes authorizationv1.ResourceAttributes) bool {
+		switch {
+		case attributes.Name == "devcluster1" && attributes.Verb == "metrics/nswebhook":
+			return true
+		case attributes.Name == "devcluster2" && attributes.Verb == "metrics/nsred2":
+			return false
+		default:
+			return attributes.Verb == "metrics/nsred1" || attributes.Verb == "metrics/nsred2"
+		}
+	}
+
+	rbacEngine, err := NewAccessReviewer(nil, newFakeAuthorizerClient(redMetricsRules, authorize),
+		WithMetricsAccessVerification(MetricsAccessVerification{Verify: true, Namespaces: []string{"nswebhook"}}))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedDetails := MetricsAccessDetails{
+		"devcluster1": {"nsred1": AccessVerified, "nsred2": AccessVerified, "nswebhook": AccessVerified},
+		"devcluster2": {"nsred1": AccessVerified},
+	}
+
+	gotDetails, err := rbacEngine.GetMetricsAccessDetails(context.TODO(), "")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !compareMetricsAccessDetails(expectedDetails, gotDetails) {
+		t.Fatalf("expected result : %v , got  : %v", expectedDetails, gotDetails)
+	}
+
+	expectedResult := map[string][]string{
+		"devcluster1": {"nsred1", "nsred2", "nswebhook"},
+		"devcluster2": {"nsred1"},
+		"devcluster3": {},
+	}
+
+	gotResult, err := rbacEngine.GetMetricsAccess("", "devcluster1", "devcluster2", "devcluster3")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !compareMetricsAccessResults(expectedResult, gotResult) {
+		t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+	}
+}
+
+func compareMetricsAccessDetails(expectedResults MetricsAccessDetails, gotResults MetricsAccessDetails) bool {
+	if len(expectedResults) != len(gotResults) {
+		return false
+	}
+
+	for expCluster, expNamespaces := range expectedResults {
+		gotNamespaces, ok := gotResults[expCluster]
+		if !ok || len(expNamespaces) != len(gotNamespaces) {
+			return false
+		}
+
+		for expNS, expSource := range expNamespaces {
+			if gotNamespaces[expNS] != expSource {
+				return false
+			}
+		}
+	}
+
+	return true
+}
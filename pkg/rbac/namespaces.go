@@ -0,0 +1,133 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+// NamespaceSource returns the candidate namespaces in which GetNamespacesWithAccess reviews the user's access.
+type NamespaceSource func(ctx context.Context) ([]string, error)
+
+// NamespacesFromList returns a NamespaceSource for the given namespaces.
+func NamespacesFromList(namespaces ...string) NamespaceSource {
+	return func(ctx context.Context) ([]string, error) {
+		return namespaces, nil
+	}
+}
+
+// NamespacesFromLister returns a NamespaceSource for the namespaces in the given lister,
+// e.g. the lister of a shared informer on namespaces.
+func NamespacesFromLister(lister listersv1.NamespaceLister) NamespaceSource {
+	return func(ctx context.Context) ([]string, error) {
+		namespaces, err := lister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+
+		return namespaceNames(namespaces), nil
+	}
+}
+
+// GetNamespacesWithAccess returns the sorted names of the namespaces in which the user is allowed the verb
+// on all resources of the given type, e.g. the "list" verb on "pods". The user's rules are reviewed in each
+// of the candidate namespaces in parallel, with at most the number set by WithConcurrency in-flight at a time,
+// and the rules cache is used if enabled on the AccessReviewer.
+// If the review fails in any of the namespaces, an error is returned for the first of them.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - gr is the API Group and Resource type and verb is the action on it
+//
+// - candidates returns the namespaces to review, see NamespacesFromList and NamespacesFromLister.
+// If nil, all the namespaces on the k8s cluster are listed with the identity of the AccessReviewer's
+// k8s config or client.
+func (r *AccessReviewer) GetNamespacesWithAccess(
+	ctx context.Context, userToken string, gr schema.GroupResource, verb string, candidates NamespaceSource,
+) ([]string, error) {
+	klog.V(2).Infof("GetNamespacesWithAccess for GroupResource: %s, verb: %s", gr, verb)
+
+	if candidates == nil {
+		candidates = r.listNamespaces
+	}
+
+	namespaces, err := candidates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the candidate namespaces: %w", err)
+	}
+
+	namespaces = addUniqueItems([]string{}, namespaces...)
+	allowed := make([]bool, len(namespaces))
+	errs := make([]error, len(namespaces))
+
+	runConcurrently(len(namespaces), r.getConcurrency(), func(i int) {
+		if namespaces[i] == "" {
+			return
+		}
+
+		resourceRules, err := r.getResourceRulesForUser(ctx, userToken, namespaces[i])
+		if err != nil {
+			errs[i] = err
+
+			return
+		}
+
+		allowed[i] = getResourceAccessFromRules(resourceRules, gr, nil).AllowsAll(verb)
+	})
+
+	namespacesWithAccess := []string{}
+
+	for i, namespace := range namespaces {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to get the user's rules in namespace %s: %w", namespace, errs[i])
+		}
+
+		if allowed[i] {
+			namespacesWithAccess = append(namespacesWithAccess, namespace)
+		}
+	}
+
+	sort.Strings(namespacesWithAccess)
+
+	klog.V(2).Infof("Namespaces with %s access on %s: %v", verb, gr, namespacesWithAccess)
+
+	return namespacesWithAccess, nil
+}
+
+// listNamespaces is a NamespaceSource that lists all the namespaces on the k8s cluster
+// with the identity of the AccessReviewer's k8s config or client
+func (r *AccessReviewer) listNamespaces(ctx context.Context) ([]string, error) {
+	kclient, err := r.getHubKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceList, err := kclient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, wrapAPIError(err)
+	}
+
+	namespaces := make([]*corev1.Namespace, len(namespaceList.Items))
+	for i := range namespaceList.Items {
+		namespaces[i] = &namespaceList.Items[i]
+	}
+
+	return namespaceNames(namespaces), nil
+}
+
+// namespaceNames returns the names of the given namespaces
+func namespaceNames(namespaces []*corev1.Namespace) []string {
+	names := make([]string, len(namespaces))
+	for i, namespace := range namespaces {
+		names[i] = namespace.Name
+	}
+
+	return names
+}
@@ -0,0 +1,98 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetNamespacesWithAccess(t *testing.T) {
+	t.Parallel()
+
+	namespaces := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsblue1"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsblue2"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsred1"}},
+	}
+
+	// the user can list pods in the blue namespaces, and only get a specific pod in the red one
+	fakeClient := fake.NewSimpleClientset(namespaces...)
+	fakeClient.PrependReactor("create", "selfsubjectrulesreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+
+			switch review.Spec.Namespace {
+			case "nsblue1", "nsblue2":
+				review.Status.ResourceRules = []authorizationv1.ResourceRule{
+					{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+				}
+			case "nsred1":
+				review.Status.ResourceRules = []authorizationv1.ResourceRule{
+					{
+						Verbs:         []string{"*"},
+						APIGroups:     []string{""},
+						Resources:     []string{"pods"},
+						ResourceNames: []string{"pod1"},
+					},
+				}
+			case "failing":
+				return true, nil, errors.New("review failed")
+			}
+
+			return true, review, nil
+		})
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range namespaces {
+		if err := indexer.Add(namespace); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	rbacEngine, err := NewAccessReviewer(nil, fakeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	pods := schema.GroupResource{Resource: "pods"}
+
+	testcases := []struct {
+		verb               string
+		candidates         NamespaceSource
+		expectedNamespaces []string
+	}{
+		{"list", nil, []string{"nsblue1", "nsblue2"}}, // all the namespaces on the cluster
+		{"list", NamespacesFromList("nsred1", "nsblue2", "nsblue2"), []string{"nsblue2"}},
+		{"list", NamespacesFromLister(listersv1.NewNamespaceLister(indexer)), []string{"nsblue1", "nsblue2"}},
+		{"delete", nil, []string{}},
+		{"list", NamespacesFromList(), []string{}},
+	}
+
+	for _, test := range testcases {
+		gotNamespaces, err := rbacEngine.GetNamespacesWithAccess(context.TODO(), "", pods, test.verb, test.candidates)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !slices.Equal(test.expectedNamespaces, gotNamespaces) {
+			t.Fatalf("expected namespaces : %v , got  : %v", test.expectedNamespaces, gotNamespaces)
+		}
+	}
+
+	_, err = rbacEngine.GetNamespacesWithAccess(context.TODO(), "", pods, "list",
+		NamespacesFromList("nsblue1", "failing"))
+	if err == nil {
+		t.Fatalf("expected err for namespace failing, got err: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package rbac
+
+import (
+	"context"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/klog"
+)
+
+// GetNonResourceAccess returns the verbs the user is allowed on non-resource URLs, e.g. "get" on "/healthz".
+// It returns a map of the given paths and the verbs allowed on them, a path without any allowed verbs
+// is returned with an empty list. A "*" verb allows all verbs.
+// As in k8s RBAC, a rule for the "*" URL matches all paths and a rule URL ending with "*" matches the paths
+// starting with the rest of it, e.g. "/apis/*" matches "/apis/apps/v1".
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - paths are the non-resource URLs for which the allowed verbs are returned. If no paths are passed,
+// the verbs of the user's rules are returned for the URLs as set in the rules, including the "*" suffixes.
+func (r *AccessReviewer) GetNonResourceAccess(
+	ctx context.Context, userToken string, paths ...string,
+) (map[string][]string, error) {
+	klog.V(2).Infof("GetNonResourceAccess for paths: %v", paths)
+
+	nonResourceRules, err := r.getNonResourceRulesForUser(ctx, userToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return getNonResourceAccessFromRules(nonResourceRules, paths), nil
+}
+
+// getNonResourceRulesForUser returns the NonResourceRules configured for the user.
+// If strict rules are enabled on the AccessReviewer, an IncompleteRulesError is returned when the rules
+// are incomplete.
+func (r *AccessReviewer) getNonResourceRulesForUser(
+	ctx context.Context, userToken string,
+) ([]authorizationv1.NonResourceRule, error) {
+	// non-resource rules are cluster-wide, so the rules review for cluster-scoped resources is used
+	rulesReview, err := r.getRulesReviewForUser(ctx, userToken, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if r.strictRules && rulesReview.Incomplete {
+		return nil, &IncompleteRulesError{EvaluationError: rulesReview.EvaluationError}
+	}
+
+	return rulesReview.NonResourceRules, nil
+}
+
+// getNonResourceAccessFromRules processes the given NonResourceRules and returns the verbs they allow
+// on the given paths, in the same form as returned by GetNonResourceAccess.
+func getNonResourceAccessFromRules(
+	nonResourceRules []authorizationv1.NonResourceRule, paths []string,
+) map[string][]string {
+	nonResourceAccess := make(map[string][]string, len(paths))
+
+	for _, path := range paths {
+		nonResourceAccess[path] = []string{}
+	}
+
+	for _, rule := range nonResourceRules {
+		if len(paths) == 0 {
+			// no paths are given, return the verbs for the URLs of the rule
+			for _, ruleURL := range rule.NonResourceURLs {
+				nonResourceAccess[ruleURL] = addUniqueItems(nonResourceAccess[ruleURL], rule.Verbs...)
+			}
+
+			continue
+		}
+
+		for _, path := range paths {
+			if nonResourceURLMatches(rule.NonResourceURLs, path) {
+				nonResourceAccess[path] = addUniqueItems(nonResourceAccess[path], rule.Verbs...)
+			}
+		}
+	}
+
+	return nonResourceAccess
+}
+
+// nonResourceURLMatches returns true if any of the non-resource URLs of a rule matches the given path,
+// either exactly or, for a rule URL ending with "*", as a prefix
+func nonResourceURLMatches(ruleURLs []string, path string) bool {
+	for _, ruleURL := range ruleURLs {
+		if ruleURL == "*" || ruleURL == path {
+			return true
+		}
+
+		if strings.HasSuffix(ruleURL, "*") && strings.HasPrefix(path, strings.TrimSuffix(ruleURL, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
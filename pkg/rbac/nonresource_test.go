@@ -0,0 +1,113 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func TestGetNonResourceAccess(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		username       string
+		kubeClient     kubernetes.Interface
+		paths          []string
+		expectedResult map[string][]string
+	}{
+		{ // every user can get the health and discovery endpoints
+			"user-red",
+			testUsers["user-red"].KubeClient,
+			[]string{"/healthz", "/apis/apps/v1", "/metrics"},
+			map[string][]string{
+				"/healthz":      {"get"},
+				"/apis/apps/v1": {"get"},
+				"/metrics":      {},
+			},
+		},
+		{ // cluster-admin should have rule * on all URLs
+			"cluster-admin",
+			baseK8sClient,
+			[]string{"/metrics"},
+			map[string][]string{
+				"/metrics": {"*"},
+			},
+		},
+		{
+			"cluster-admin",
+			baseK8sClient,
+			[]string{},
+			map[string][]string{
+				"*": {"*"},
+			},
+		},
+	}
+
+	for _, test := range testcases {
+		rbacEngine, err := NewAccessReviewer(nil, test.kubeClient)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotResult, err := rbacEngine.GetNonResourceAccess(context.TODO(), "", test.paths...)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessResults(test.expectedResult, gotResult) {
+			t.Fatalf("expected result for %s : %v , got  : %v", test.username, test.expectedResult, gotResult)
+		}
+	}
+}
+
+func TestGetNonResourceAccessFromRules(t *testing.T) {
+	t.Parallel()
+
+	nonResourceRules := []authorizationv1.NonResourceRule{
+		{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz", "/apis/*"}},
+		{Verbs: []string{"get", "post"}, NonResourceURLs: []string{"/metrics"}},
+		{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics"}},
+	}
+
+	testcases := []struct {
+		paths          []string
+		expectedResult map[string][]string
+	}{
+		{
+			[]string{"/healthz", "/healthz/ready", "/apis", "/apis/", "/apis/apps", "/metrics"},
+			map[string][]string{
+				"/healthz":       {"get"},
+				"/healthz/ready": {},
+				"/apis":          {},
+				"/apis/":         {"get"},
+				"/apis/apps":     {"get"},
+				"/metrics":       {"get", "post"},
+			},
+		},
+		{
+			nil, // the URLs of the rules
+			map[string][]string{
+				"/healthz": {"get"},
+				"/apis/*":  {"get"},
+				"/metrics": {"get", "post"},
+			},
+		},
+	}
+
+	for _, test := range testcases {
+		gotResult := getNonResourceAccessFromRules(nonResourceRules, test.paths)
+
+		if len(test.expectedResult) != len(gotResult) {
+			t.Fatalf("expected result : %v , got  : %v", test.expectedResult, gotResult)
+		}
+
+		for path, expectedVerbs := range test.expectedResult {
+			if !slices.Equal(expectedVerbs, gotResult[path]) {
+				t.Fatalf("expected verbs on %s : %v , got  : %v", path, expectedVerbs, gotResult[path])
+			}
+		}
+	}
+}
@@ -3,9 +3,10 @@ package rbac
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
 
 	"golang.org/x/exp/slices"
 	authorizationv1 "k8s.io/api/authorization/v1"
@@ -37,17 +38,77 @@ var MetricsACLConfig = ACLConfig{
 	verb: "metrics/",
 }
 
+// NewACLConfig returns an ACLConfig for a family of permissions expressed as verbs with a common prefix
+// on a resource type, e.g. "logs/<namespace>" verbs on "managedclusters", for use with GetPrefixedAccess.
+//
+// - group and resource are the API Group and Resource type the verbs are set on in the rules
+//
+// - verbPrefix is the common prefix of the verbs, including any separator, e.g. "logs/"
+func NewACLConfig(group string, resource string, verbPrefix string) ACLConfig {
+	return ACLConfig{
+		groupRes: schema.GroupResource{Group: group, Resource: resource},
+		verb:     verbPrefix,
+	}
+}
+
+// GroupResource returns the API Group and Resource type of the ACLConfig.
+func (c ACLConfig) GroupResource() schema.GroupResource {
+	return c.groupRes
+}
+
+// VerbPrefix returns the common prefix of the verbs of the ACLConfig.
+func (c ACLConfig) VerbPrefix() string {
+	return c.verb
+}
+
 // AccessReviewer is the  API for custom fined-grained access control, it holds the
 // configuration needed to connect to the Kubernetes cluster to retrieve user's access information.
 // It must be instantiated through the NewAccessReviewer function as it will do any required validation.
 type AccessReviewer struct {
 	kubeConfig *rest.Config
 	kubeClient kubernetes.Interface
+	// rulesCache holds the results of the user's SelfSubjectRulesReviews, it is nil if caching is not enabled
+	rulesCache *rulesCache
+	// userInfoCache holds the identities resolved by GetUserInfo, it is nil if caching is not enabled
+	userInfoCache *userInfoCache
+	// inflightReviews de-duplicates concurrent SelfSubjectRulesReviews for the same user and namespace
+	inflightReviews *rulesReviewGroup
+	// clientPool holds the k8s clients created for users' tokens, it is nil if pooling is not enabled
+	clientPool *clientPool
+	// concurrency is the maximum number of calls made in parallel to the cluster by an API that fans out
+	concurrency int
+	// metricsVerification configures the checks of the metrics access, it is nil if verification is not enabled
+	metricsVerification *MetricsAccessVerification
+	// strictRules turns incomplete rules into an IncompleteRulesError
+	strictRules bool
+	// rbacInvalidation holds the settings of the RBAC invalidation, it is nil if the invalidation is not enabled
+	rbacInvalidation *rbacInvalidation
+	// rbacInvalidator drops the cached results on RBAC changes, it is nil if the invalidation is not enabled
+	rbacInvalidator *rbacInvalidator
+	// auditSink receives the audit events of the access decisions, it is nil if auditing is not enabled
+	auditSink AuditSink
+	// impersonatedUser is the user impersonated by an AccessReviewer returned by Impersonate
+	impersonatedUser *UserInfo
+	// impersonatedUserHash identifies the impersonated user in the rules cache
+	impersonatedUserHash string
+	// transportLock guards hubTransport
+	transportLock sync.Mutex
+	// hubTransport is the transport with the identity of the k8s config, shared by the impersonating clients
+	hubTransport http.RoundTripper
+	// attachUserInfo adds the identity of the user to the access results
+	attachUserInfo bool
+	// identityLock guards selfSubjectReviewVersion
+	identityLock sync.Mutex
+	// selfSubjectReviewVersion is the version of the API serving SelfSubjectReviews, once discovered
+	selfSubjectReviewVersion string
 }
 
+// Option configures optional behavior of an AccessReviewer, it is passed to NewAccessReviewer.
+type Option func(*AccessReviewer)
+
 // NewAccessReviewer creates an instance of AccessReviewer.
 // It takes two parameters kConfig and kClient, but expects a value to be set for only one of them.
-// An error will be thrown if neither or both values are set.
+// An ErrNoClientConfigured or ErrAmbiguousClientConfig error is returned if neither or both values are set.
 //
 // - kConfig is k8s cluster configuration. This should be set when API consumer intends to use
 // the AccessReviewer instance to retrieve ACLs for different users. User specific details(i.e Token)
@@ -58,17 +119,19 @@ type AccessReviewer struct {
 // the AccessReviewer instance for a single user. The provided k8s client connection will be directly
 // used to fetch ACLs from the cluster. In this case, access review  API can be invoked without needing
 // to pass the user's Token on every call.
-func NewAccessReviewer(kConfig *rest.Config, kClient kubernetes.Interface) (*AccessReviewer, error) {
+//
+// - opts are optional settings for the AccessReviewer, e.g. WithRulesCache or WithClientPool.
+func NewAccessReviewer(kConfig *rest.Config, kClient kubernetes.Interface, opts ...Option) (*AccessReviewer, error) {
 	// Verify only one of k8s config or client are set
 	if kClient == nil && kConfig == nil {
-		return nil, errors.New("one of either kubeConfig or kubeClient must be a non-nil value")
+		return nil, ErrNoClientConfigured
 	}
 
 	if kClient != nil && kConfig != nil {
-		return nil, errors.New("only one of either kubeConfig or kubeClient must be a non-nil value")
+		return nil, ErrAmbiguousClientConfig
 	}
 
-	accessReviewer := new(AccessReviewer)
+	accessReviewer := &AccessReviewer{inflightReviews: &rulesReviewGroup{}}
 
 	if kConfig != nil {
 		configCopy := *kConfig
@@ -77,30 +140,33 @@ func NewAccessReviewer(kConfig *rest.Config, kClient kubernetes.Interface) (*Acc
 		accessReviewer.kubeClient = kClient
 	}
 
+	for _, opt := range opts {
+		opt(accessReviewer)
+	}
+
+	// the invalidator depends on the other options, e.g. the rules cache
+	if accessReviewer.rbacInvalidation != nil {
+		accessReviewer.startRBACInvalidator()
+	}
+
 	return accessReviewer, nil
 }
 
 // getKubeClientForUser returns the k8s client to use to connect to the cluster.
 // - userToken is the user's OAuth bearer token.  It will be used along with the k8sConfig,
 // set on the AccessReviewer, to create a new k8s client. If k8sConfig is not available,
-// then the configured k8s client is returned.
+// then the configured k8s client is returned. When the client pool is enabled, the client
+// for the userToken is served from the pool.
 func (r *AccessReviewer) getKubeClientForUser(userToken string) (kubernetes.Interface, error) {
 	if r.kubeConfig != nil {
 		// if a valid userToken
 		if userToken != "" {
-			// make a copy of the RestConfig to avoid overwrites when multiple api calls are made in parallel
-			userKubeConfig := &rest.Config{
-				Host:    r.kubeConfig.Host,
-				APIPath: r.kubeConfig.APIPath,
-				TLSClientConfig: rest.TLSClientConfig{
-					CAFile:     r.kubeConfig.TLSClientConfig.CAFile,
-					CAData:     r.kubeConfig.TLSClientConfig.CAData,
-					ServerName: r.kubeConfig.TLSClientConfig.ServerName,
-					// For testing
-					Insecure: r.kubeConfig.TLSClientConfig.Insecure,
-				},
+			if r.clientPool != nil {
+				return r.clientPool.get(r.getAnonymousKubeConfig(), userToken)
 			}
 
+			userKubeConfig := r.getAnonymousKubeConfig()
+
 			// tokenfile takes precedence over token,
 			// set tokenfile to empty to ensure token is used
 			userKubeConfig.BearerTokenFile = ""
@@ -114,15 +180,130 @@ func (r *AccessReviewer) getKubeClientForUser(userToken string) (kubernetes.Inte
 			return kclient, nil
 		}
 
-		return nil, fmt.Errorf(
-			"failed to get a client to connect to the kubernetes cluster:" +
-				"When KubeConfig is provided, a valid userToken must be set on all access review calls")
+		return nil, fmt.Errorf("failed to get a client to connect to the kubernetes cluster: %w", ErrMissingToken)
 	}
 
 	// if kubeConfig isnt set then return the kubeClient set
 	return r.kubeClient, nil
 }
 
+// getHubKubeClient returns the k8s client with the identity of the k8s config or client set on the AccessReviewer,
+// rather than a user's identity.
+func (r *AccessReviewer) getHubKubeClient() (kubernetes.Interface, error) {
+	if r.kubeConfig == nil {
+		return r.kubeClient, nil
+	}
+
+	kclient, err := kubernetes.NewForConfig(r.kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a client to connect to the kubernetes cluster: %w", err)
+	}
+
+	return kclient, nil
+}
+
+// getAnonymousKubeConfig returns a copy of the k8s config set on the AccessReviewer without any credentials,
+// from which the k8s config for a user is derived by setting the user's token.
+// All transport and tuning settings (e.g. QPS, Burst, Timeout, Proxy, Dial, UserAgent, RateLimiter,
+// WrapTransport, CA and TLS server settings) are kept, while the credentials of the k8s config, i.e.
+// token, token file, basic auth, client certificates, exec and auth providers and impersonation are dropped.
+func (r *AccessReviewer) getAnonymousKubeConfig() *rest.Config {
+	// make a copy of the RestConfig to avoid overwrites when multiple api calls are made in parallel,
+	// AnonymousClientConfig copies all the settings other than the credentials
+	anonymousConfig := rest.AnonymousClientConfig(r.kubeConfig)
+
+	// WrapTransport isn't copied by AnonymousClientConfig as it may be used to add credentials,
+	// but it is also how transport tuning (e.g. tracing, metrics) is set up, so keep it
+	anonymousConfig.WrapTransport = r.kubeConfig.WrapTransport
+
+	return anonymousConfig
+}
+
+// getResourceRulesForUser returns the ResourceRules configured for the user in the given namespace.
+// If strict rules are enabled on the AccessReviewer, an IncompleteRulesError is returned when the rules
+// are incomplete.
+func (r *AccessReviewer) getResourceRulesForUser(
+	ctx context.Context, userToken string, namespace string,
+) ([]authorizationv1.ResourceRule, error) {
+	rulesReview, err := r.getRulesReviewForUser(ctx, userToken, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.strictRules && rulesReview.Incomplete {
+		return nil, &IncompleteRulesError{Namespace: namespace, EvaluationError: rulesReview.EvaluationError}
+	}
+
+	return rulesReview.ResourceRules, nil
+}
+
+// getRulesReviewForUser returns the result of the user's SelfSubjectRulesReview in the given namespace.
+// When the rules cache is enabled on the AccessReviewer, the result is served from it if present,
+// otherwise it is retrieved with a SelfSubjectRulesReview and added to the cache.
+// Concurrent calls for the same user and namespace are collapsed into a single SelfSubjectRulesReview.
+func (r *AccessReviewer) getRulesReviewForUser(
+	ctx context.Context, userToken string, namespace string,
+) (*RulesReviewResult, error) {
+	tokenHash := r.cacheKeyHash(userToken)
+
+	if r.rulesCache != nil {
+		rulesReview, ok := r.rulesCache.get(tokenHash, namespace)
+		recordCacheResult(ctx, ok)
+
+		if ok {
+			klog.V(2).Infof("Resource rules for namespace %s served from the cache", namespace)
+
+			return rulesReview, nil
+		}
+	}
+
+	// get Client to talk to the Kubernetes cluster
+	userKClient, err := r.getKubeClientForUser(userToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// concurrent calls for the same user and namespace share a single SelfSubjectRulesReview
+	return r.inflightReviews.do(ctx, tokenHash, namespace,
+		func(reviewCtx context.Context) (*RulesReviewResult, error) {
+			var generation uint64
+			if r.rulesCache != nil {
+				generation = r.rulesCache.generation(namespace)
+			}
+
+			rulesReview, err := makeRulesReviewForUser(reviewCtx, userKClient, namespace)
+			if err != nil {
+				return nil, err
+			}
+
+			// the result isn't cached if the RBAC resources changed while it was being reviewed
+			if r.rulesCache != nil && !r.rulesCache.addIfCurrent(tokenHash, namespace, rulesReview, generation) {
+				klog.V(2).Infof("Resource rules for namespace %s not cached as they were invalidated", namespace)
+			}
+
+			return rulesReview, nil
+		})
+}
+
+// GetResourceAccessForUser is the same as GetResourceAccessWithContext, but it retrieves the ACLs of
+// the user with the k8s configuration or client set on the AccessReviewer. The rules cache is used
+// if enabled and concurrent calls for the same user and namespace share a single SelfSubjectRulesReview.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+func (r *AccessReviewer) GetResourceAccessForUser(
+	ctx context.Context, userToken string, gr schema.GroupResource, resourcenames []string, namespace string,
+) (ResourceAccess, error) {
+	klog.V(2).Infof("GetResourceAccessForUser for GroupResource: %s, resourcenames: %v, namespace: %s",
+		gr, resourcenames, namespace)
+
+	resourceRules, err := r.getResourceRulesForUser(ctx, userToken, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return getResourceAccessFromRules(resourceRules, gr, resourcenames), nil
+}
+
 // GetMetricsAccess retrieves the user's ACLs from the k8s cluster  and processes them to determine
 // user's access to observability metrics that are gathered from managed clusters.
 // It returns a map where the keys are managed clusters and the values are slices of allowed namespaces.
@@ -132,62 +313,120 @@ func (r *AccessReviewer) getKubeClientForUser(userToken string) (kubernetes.Inte
 // - clusters are the  names of the managed clusters for which  allowed metrics access is returned.
 // If no clusters are specified, then  metrics access is returned for all "allowed" managed clusters.
 func (r *AccessReviewer) GetMetricsAccess(userToken string, clusters ...string) (map[string][]string, error) {
+	return r.GetMetricsAccessWithContext(context.TODO(), userToken, clusters...)
+}
+
+// GetMetricsAccessWithContext is the same as GetMetricsAccess, but the given context is used for the calls
+// made to the k8s cluster, so cancellation, deadlines and request-scoped values are propagated to them.
+func (r *AccessReviewer) GetMetricsAccessWithContext(
+	ctx context.Context, userToken string, clusters ...string,
+) (map[string][]string, error) {
 	klog.V(2).Infof("GetMetricsAccess for clusters: %v", clusters)
 
-	// get Client to talk to the Kubernetes cluster
-	userKClient, err := r.getKubeClientForUser(userToken)
-	if err != nil {
-		return nil, err
-	}
+	return r.audited(ctx, "GetMetricsAccess", userToken, clusters,
+		func(ctx context.Context) (map[string][]string, error) {
+			// when verification is enabled, the rules-derived access is checked with SelfSubjectAccessReviews
+			if r.metricsVerification != nil {
+				metricsAccessDetails, err := r.GetMetricsAccessDetails(ctx, userToken, clusters...)
+				if err != nil {
+					return nil, err
+				}
 
-	// get all user ACLs on ManagedCluster resources
-	resourceACLs, err := GetResourceAccess(userKClient, MetricsACLConfig.groupRes, clusters, "")
-	if err != nil {
-		return nil, err
-	}
+				return metricsAccessDetails.namespaces(), nil
+			}
+
+			return r.getInferredMetricsAccess(ctx, userToken, clusters)
+		})
+}
+
+// getInferredMetricsAccess returns the user's access to metrics as derived from the user's rules,
+// in the same form as returned by GetMetricsAccess.
+func (r *AccessReviewer) getInferredMetricsAccess(
+	ctx context.Context, userToken string, clusters []string,
+) (map[string][]string, error) {
+	return r.GetPrefixedAccess(ctx, userToken, MetricsACLConfig, clusters...)
+}
+
+// GetPrefixedAccess returns the user's access for the family of permissions described by the given ACLConfig,
+// i.e. the verbs starting with its verb prefix on resources of its type, e.g. "logs/<namespace>" verbs
+// on "managedclusters". It returns a map of resource names and the suffixes of the allowed verbs,
+// in the same form as GetMetricsAccess returns clusters and namespaces.
+// If all verbs are allowed on a resource, "*" is returned as its suffix.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - cfg is the ACLConfig of the permissions, created with NewACLConfig
+//
+// - names are the names of the resources for which access is checked and returned,
+// if no names are passed, access for all allowed resources of the type is returned.
+func (r *AccessReviewer) GetPrefixedAccess(
+	ctx context.Context, userToken string, cfg ACLConfig, names ...string,
+) (map[string][]string, error) {
+	klog.V(2).Infof("GetPrefixedAccess for %v with verb prefix %s for: %v", cfg.groupRes, cfg.verb, names)
+
+	return r.audited(ctx, "GetPrefixedAccess", userToken, names,
+		func(ctx context.Context) (map[string][]string, error) {
+			// get all user rules for cluster scoped resources
+			resourceRules, err := r.getResourceRulesForUser(ctx, userToken, "")
+			if err != nil {
+				return nil, err
+			}
+
+			return getPrefixedAccessFromRules(resourceRules, cfg, names), nil
+		})
+}
+
+// getPrefixedAccessFromRules processes the given ResourceRules and returns the access they grant for
+// the family of permissions described by the ACLConfig, in the same form as returned by GetPrefixedAccess.
+func getPrefixedAccessFromRules(
+	resourceRules []authorizationv1.ResourceRule, cfg ACLConfig, names []string,
+) map[string][]string {
+	// get all user ACLs on the resources
+	resourceACLs := getResourceAccessFromRules(resourceRules, cfg.groupRes, names)
 
 	klog.V(2).Infof(" resource access results: %v", resourceACLs)
 
-	// from the list of all ACLs for ManagedCluster, filter out the "metrics" specific acls and grab the namespaces
-	metricsAccessResults := make(map[string][]string, len(resourceACLs))
+	// from the list of all ACLs for the resources, filter out the verbs with the prefix and grab their suffixes
+	prefixedAccessResults := make(map[string][]string, len(resourceACLs))
 
-	for clustername, clusteracls := range resourceACLs {
-		klog.V(2).Infof("clustername [%s] acls[%s]\n", clustername, clusteracls)
+	for name, acls := range resourceACLs {
+		klog.V(2).Infof("name [%s] acls[%s]\n", name, acls)
 
-		// list of namespaces for the cluster
-		metricsAccessMap := make(map[string]bool, len(clusteracls))
+		// list of suffixes for the resource
+		suffixesMap := make(map[string]bool, len(acls))
 
-		for _, acl := range clusteracls {
-			// filter verbs that start with metrics/ and grab the namespace
-			// if verb is set to *, set namespace to * to indicate access to all namespaces
-			if strings.HasPrefix(acl, MetricsACLConfig.verb) {
-				metricsAccessMap[strings.TrimPrefix(acl, MetricsACLConfig.verb)] = true
+		for _, acl := range acls {
+			// filter verbs that start with the prefix and grab the suffix
+			// if verb is set to *, set suffix to * to indicate access to all of them
+			if strings.HasPrefix(acl, cfg.verb) {
+				suffixesMap[strings.TrimPrefix(acl, cfg.verb)] = true
 			} else if acl == "*" {
-				metricsAccessMap["*"] = true
+				suffixesMap["*"] = true
 			}
 		}
 
-		nsWithMetricsAccess := make([]string, 0, len(metricsAccessMap))
-		for ns := range metricsAccessMap {
-			nsWithMetricsAccess = append(nsWithMetricsAccess, ns)
+		suffixes := make([]string, 0, len(suffixesMap))
+		for suffix := range suffixesMap {
+			suffixes = append(suffixes, suffix)
 		}
 
-		klog.V(2).Infof("clustername [%s], Namespaces with metrics access[%s]\n", clustername, nsWithMetricsAccess)
+		klog.V(2).Infof("name [%s], allowed suffixes [%s]\n", name, suffixes)
 
-		// add cluster to returned map if metrics acls are set for it
-		if len(nsWithMetricsAccess) > 0 || slices.Contains(clusters, clustername) {
-			metricsAccessResults[clustername] = nsWithMetricsAccess
+		// add resource to returned map if prefixed acls are set for it
+		if len(suffixes) > 0 || slices.Contains(names, name) {
+			prefixedAccessResults[name] = suffixes
 		}
 	}
 
-	klog.V(2).Infof(" metricsAccessResults is %v", metricsAccessResults)
+	klog.V(2).Infof(" prefixedAccessResults is %v", prefixedAccessResults)
 
-	return metricsAccessResults, nil
+	return prefixedAccessResults
 }
 
 // GetResourceAccess returns all configured ACLs for a given resource type.
 // It returns a map of resource names and ACLs for that resource. for a given resource,
 // if no  ACLs are configured, an empty list is returned for it in the results.
+// See ResourceAccess for helpers that take the "*" resource name and verb into account.
 //
 // - resourcenames are the names of the resources for which ACLs are and returned,
 // if no resource names are passed, ACLs for all allowed resources of the given type are returned.
@@ -196,17 +435,73 @@ func (r *AccessReviewer) GetMetricsAccess(userToken string, clusters ...string)
 // If not specified, it defaults to the value "default" for namespace-scoped resources.
 func GetResourceAccess(
 	kclient kubernetes.Interface, gr schema.GroupResource, resourcenames []string, namespace string,
-) (map[string][]string, error) {
+) (ResourceAccess, error) {
+	return GetResourceAccessWithContext(context.TODO(), kclient, gr, resourcenames, namespace)
+}
+
+// GetResourceAccessWithContext is the same as GetResourceAccess, but the given context is used for the
+// SelfSubjectRulesReview call made to the k8s cluster.
+func GetResourceAccessWithContext(
+	ctx context.Context, kclient kubernetes.Interface, gr schema.GroupResource, resourcenames []string,
+	namespace string,
+) (ResourceAccess, error) {
 	klog.V(2).Infof(
 		"GetResourceAccess for GroupResource: %s, resourcenames: %v, namespace: %s", gr, resourcenames, namespace)
 
 	// make a SelfSubjectRulesReview to get all resource rules.
-	resourceRules, err := makeSubjectRulesReviewForUser(kclient, namespace)
+	resourceRules, err := makeSubjectRulesReviewForUserWithContext(ctx, kclient, namespace)
 	if err != nil {
 		return nil, err
 	}
 
-	resourceAccessResults := make(map[string][]string)
+	return getResourceAccessFromRules(resourceRules, gr, resourcenames), nil
+}
+
+// GetResourceAccessInNamespaces is the same as GetResourceAccessForUser, but returns the ACLs for a given
+// namespace-scoped resource type in each of the given namespaces. The user's rules are reviewed in the
+// namespaces in parallel, with at most the number set by WithConcurrency in-flight at a time.
+// It returns a map of namespaces and the ACLs in them, if the review fails in any of the namespaces,
+// an error is returned for the first of them in the given order.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - resourcenames are the names of the resources for which ACLs are and returned,
+// if no resource names are passed, ACLs for all allowed resources of the given type are returned.
+//
+// - namespaces are the namespaces the ACLs are returned for, duplicates are ignored.
+func (r *AccessReviewer) GetResourceAccessInNamespaces(
+	ctx context.Context, userToken string, gr schema.GroupResource, resourcenames []string, namespaces []string,
+) (map[string]ResourceAccess, error) {
+	klog.V(2).Infof("GetResourceAccessInNamespaces for GroupResource: %s, resourcenames: %v, namespaces: %v",
+		gr, resourcenames, namespaces)
+
+	namespaces = addUniqueItems([]string{}, namespaces...)
+	accesses := make([]ResourceAccess, len(namespaces))
+	errs := make([]error, len(namespaces))
+
+	runConcurrently(len(namespaces), r.getConcurrency(), func(i int) {
+		accesses[i], errs[i] = r.GetResourceAccessForUser(ctx, userToken, gr, resourcenames, namespaces[i])
+	})
+
+	namespacesAccess := make(map[string]ResourceAccess, len(namespaces))
+
+	for i, namespace := range namespaces {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to get the resource access in namespace %s: %w", namespace, errs[i])
+		}
+
+		namespacesAccess[namespace] = accesses[i]
+	}
+
+	return namespacesAccess, nil
+}
+
+// getResourceAccessFromRules processes the given ResourceRules and returns the ACLs they grant
+// for the given resource type, in the same form as returned by GetResourceAccess.
+func getResourceAccessFromRules(
+	resourceRules []authorizationv1.ResourceRule, gr schema.GroupResource, resourcenames []string,
+) ResourceAccess {
+	resourceAccessResults := make(ResourceAccess)
 	// search through all the resource rules
 	for _, rule := range resourceRules {
 		// each resource rule contains { []ApiGroup, []Resources, []ResourceNames, []Verbs}
@@ -214,7 +509,7 @@ func GetResourceAccess(
 		// [managedclusters] [devcluster1 devcluster2]}
 		// filter the rules by the given ApiGroup(or *) and Resource(or *))
 		ruleMatchesAPIGroup := (slices.Contains(rule.APIGroups, gr.Group) || slices.Contains(rule.APIGroups, "*"))
-		ruleMatchesResource := (slices.Contains(rule.Resources, gr.Resource) || slices.Contains(rule.Resources, "*"))
+		ruleMatchesResource := resourceMatches(rule.Resources, gr.Resource)
 
 		if !(ruleMatchesAPIGroup && ruleMatchesResource) {
 			continue
@@ -261,7 +556,30 @@ func GetResourceAccess(
 
 	klog.V(2).Infof("Resource access results %v", resourceAccessResults)
 
-	return resourceAccessResults, nil
+	return resourceAccessResults
+}
+
+// resourceMatches returns true if any of the resources of a rule matches the given resource, which may be a
+// subresource in the "resource/subresource" form, e.g. "pods/log". As in k8s RBAC, the "*" rule resource matches
+// all resources and subresources, and a subresource is also matched by the "*/subresource" rule resource, while
+// a rule for a resource does not match its subresources.
+func resourceMatches(ruleResources []string, resource string) bool {
+	if slices.Contains(ruleResources, "*") || slices.Contains(ruleResources, resource) {
+		return true
+	}
+
+	_, subresource, ok := strings.Cut(resource, "/")
+	if !ok {
+		return false
+	}
+
+	return slices.Contains(ruleResources, "*/"+subresource)
+}
+
+// GroupSubresource returns the GroupResource to request the access for a subresource, e.g. "log" of "pods",
+// with APIs such as GetResourceAccess. Its Resource is in the "resource/subresource" form used by k8s RBAC rules.
+func GroupSubresource(group string, resource string, subresource string) schema.GroupResource {
+	return schema.GroupResource{Group: group, Resource: resource + "/" + subresource}
 }
 
 // addUniqueItems a convenience method for building a slice with unique entries
@@ -293,6 +611,27 @@ func addUniqueItems(itemlist []string, itemsToAdd ...string) []string {
 func makeSubjectRulesReviewForUser(
 	kclient kubernetes.Interface, namespace string,
 ) ([]authorizationv1.ResourceRule, error) {
+	return makeSubjectRulesReviewForUserWithContext(context.TODO(), kclient, namespace)
+}
+
+// makeSubjectRulesReviewForUserWithContext is the same as makeSubjectRulesReviewForUser, but the given
+// context is used for the SelfSubjectRulesReview call.
+func makeSubjectRulesReviewForUserWithContext(
+	ctx context.Context, kclient kubernetes.Interface, namespace string,
+) ([]authorizationv1.ResourceRule, error) {
+	rulesReview, err := makeRulesReviewForUser(ctx, kclient, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return rulesReview.ResourceRules, nil
+}
+
+// makeRulesReviewForUser is the same as makeSubjectRulesReviewForUserWithContext, but it returns the full
+// result of the SelfSubjectRulesReview, including whether the rules are incomplete.
+func makeRulesReviewForUser(
+	ctx context.Context, kclient kubernetes.Interface, namespace string,
+) (*RulesReviewResult, error) {
 	klog.V(2).Infof("Make Subject Access Rules Review for Namespace %s", namespace)
 
 	// selfsubjectaccessreview needs to be  for a specific namespace
@@ -314,10 +653,9 @@ func makeSubjectRulesReviewForUser(
 		},
 	}
 
-	response, err := kclient.AuthorizationV1().SelfSubjectRulesReviews().Create(
-		context.TODO(), sarr, metav1.CreateOptions{})
+	response, err := kclient.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, sarr, metav1.CreateOptions{})
 	if err != nil {
-		return nil, err
+		return nil, wrapAPIError(err)
 	}
 
 	sarrStatus := response.Status
@@ -329,7 +667,16 @@ func makeSubjectRulesReviewForUser(
 		)
 	}
 
+	if sarrStatus.Incomplete {
+		klog.Infof("SelfSubjectRulesReview in namespace %s returned incomplete rules", namespace)
+	}
+
 	klog.V(2).Infof("Resources Rule : %v", sarrStatus.ResourceRules)
 
-	return sarrStatus.ResourceRules, nil
+	return &RulesReviewResult{
+		ResourceRules:    sarrStatus.ResourceRules,
+		NonResourceRules: sarrStatus.NonResourceRules,
+		Incomplete:       sarrStatus.Incomplete,
+		EvaluationError:  sarrStatus.EvaluationError,
+	}, nil
 }
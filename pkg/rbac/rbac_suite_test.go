@@ -169,6 +169,92 @@ roleRef:
 ---
 `
 
+const logsAccessYaml = `
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: view-logs
+rules:
+  - apiGroups:
+      - "cluster.open-cluster-management.io"
+    resources:
+      - managedclusters
+    resourceNames:
+      - devcluster1
+    verbs:
+      - logs/nsblue1
+      - logs/nsblue2
+      - metrics/nsblue1
+  - apiGroups:
+      - "cluster.open-cluster-management.io"
+    resources:
+      - managedclusters
+    verbs:
+      - logs/kube-system
+---
+kind: ClusterRoleBinding
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: view-logs-binding
+subjects:
+  - kind: Group
+    apiGroup: rbac.authorization.k8s.io
+    name: log-viewers
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: view-logs
+---
+`
+
+const subresourcesAccessYaml = `
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: view-subresources
+rules:
+  - apiGroups:
+      - "cluster.open-cluster-management.io"
+    resources:
+      - managedclusters/status
+    verbs:
+      - get
+  - apiGroups:
+      - ""
+    resources:
+      - pods/log
+    verbs:
+      - get
+  - apiGroups:
+      - "apps"
+    resources:
+      - deployments/*
+    verbs:
+      - get
+  - apiGroups:
+      - "apps"
+    resources:
+      - "*/scale"
+    verbs:
+      - update
+---
+kind: ClusterRoleBinding
+apiVersion: rbac.authorization.k8s.io/v1
+metadata:
+  name: view-subresources-binding
+subjects:
+  - kind: Group
+    apiGroup: rbac.authorization.k8s.io
+    name: subresource-viewers
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: view-subresources
+---
+`
+
 var (
 	baseK8sConfig *rest.Config
 	baseK8sClient kubernetes.Interface
@@ -207,9 +293,18 @@ var (
 			nil, // set after startUp
 			[]string{"view-all-default-namespace"},
 		},
+		"user-log-viewer": {
+			nil, // set after startUp
+			[]string{"log-viewers"},
+		},
+		"user-subresource-viewer": {
+			nil, // set after startUp
+			[]string{"subresource-viewers"},
+		},
 	}
 	testRbacResourceYamls = []string{
 		blueMetricsAccessYaml, redMetricsAccessYaml, systemMetricsAccessOnAllClusterYaml, viewAllDefaultNamespace,
+		logsAccessYaml, subresourcesAccessYaml,
 	}
 )
 
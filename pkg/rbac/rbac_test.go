@@ -1,13 +1,27 @@
 package rbac
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 func TestNewAccessReviewer(t *testing.T) {
@@ -23,18 +37,18 @@ func TestNewAccessReviewer(t *testing.T) {
 		{
 			nil,
 			nil,
-			errors.New("one of either kubeConfig or kubeClient must be a non-nil value"),
+			ErrNoClientConfigured,
 		},
 		{
 			baseK8sClient,
 			baseK8sConfig,
-			errors.New("only one of either kubeConfig or kubeClient must be a non-nil value"),
+			ErrAmbiguousClientConfig,
 		},
 	}
 
 	for _, test := range testcases {
 		_, err := NewAccessReviewer(test.kubeConfig, test.kubeClient)
-		if !(errors.Is(err, test.expectedErr) || strings.EqualFold(test.expectedErr.Error(), err.Error())) {
+		if !errors.Is(err, test.expectedErr) {
 			t.Fatalf("expected err: %s got err: %s", test.expectedErr, err)
 		}
 	}
@@ -173,6 +187,103 @@ func TestGetMetricsAccess(t *testing.T) {
 	}
 }
 
+func TestGetResourceAccessInNamespaces(t *testing.T) {
+	t.Parallel()
+
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+
+	rbacEngine, err := NewAccessReviewer(nil, testUsers["user-view-all-default-namespace"].KubeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	gotResult, err := rbacEngine.GetResourceAccessInNamespaces(context.TODO(), "", configMaps, []string{"cm1"},
+		[]string{"default", "kube-system", "default"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(gotResult) != 2 {
+		t.Fatalf("expected num of namespaces : %d , got  : %d", 2, len(gotResult))
+	}
+
+	if !gotResult["default"].Allows("cm1", "get") || gotResult["kube-system"].Allows("cm1", "get") {
+		t.Fatalf("expected get to be allowed on cm1 only in the default namespace, got  : %v", gotResult)
+	}
+}
+
+func TestGetResourceAccessInNamespacesConcurrency(t *testing.T) {
+	t.Parallel()
+
+	var (
+		lock        sync.Mutex
+		inflight    int
+		maxInflight int
+	)
+
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "selfsubjectrulesreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lock.Lock()
+			inflight++
+			if inflight > maxInflight {
+				maxInflight = inflight
+			}
+			lock.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			lock.Lock()
+			inflight--
+			lock.Unlock()
+
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+			if review.Spec.Namespace == "failing" {
+				return true, nil, errors.New("review failed")
+			}
+
+			review.Status.ResourceRules = []authorizationv1.ResourceRule{
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"configmaps"}},
+			}
+
+			return true, review, nil
+		})
+
+	const concurrency = 3
+
+	rbacEngine, err := NewAccessReviewer(nil, fakeClient, WithConcurrency(concurrency))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+
+	namespaces := make([]string, 3*concurrency)
+	for i := range namespaces {
+		namespaces[i] = fmt.Sprintf("ns%d", i)
+	}
+
+	gotResult, err := rbacEngine.GetResourceAccessInNamespaces(context.TODO(), "", configMaps, nil, namespaces)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, namespace := range namespaces {
+		if !gotResult[namespace].AllowsAll("get") {
+			t.Fatalf("expected get to be allowed on all configmaps in %s, got  : %v", namespace, gotResult[namespace])
+		}
+	}
+
+	if maxInflight > concurrency {
+		t.Fatalf("expected at most %d reviews in parallel, got  : %d", concurrency, maxInflight)
+	}
+
+	_, err = rbacEngine.GetResourceAccessInNamespaces(context.TODO(), "", configMaps, nil, []string{"ns1", "failing"})
+	if err == nil || !strings.Contains(err.Error(), "failing") {
+		t.Fatalf("expected err for namespace failing, got err: %v", err)
+	}
+}
+
 func compareMetricsAccessResults(expectedResults map[string][]string, gotResults map[string][]string) bool {
 	// compare length
 	if len(expectedResults) != len(gotResults) {
@@ -230,3 +341,280 @@ func TestMakeSubjectRulesReviewForUser(t *testing.T) {
 		}
 	}
 }
+
+func TestGetMetricsAccessWithContext(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, err := NewAccessReviewer(nil, testUsers["user-red"].KubeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	gotResult, err := rbacEngine.GetMetricsAccessWithContext(context.TODO(), "", "devcluster1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedResult := map[string][]string{"devcluster1": {"nsred1", "nsred2"}}
+	if !compareMetricsAccessResults(expectedResult, gotResult) {
+		t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+	}
+
+	// a cancelled context must stop the call to the cluster
+	cancelledCtx, cancelFunc := context.WithCancel(context.TODO())
+	cancelFunc()
+
+	_, err = rbacEngine.GetMetricsAccessWithContext(cancelledCtx, "", "devcluster1")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected err: %s got err: %v", context.Canceled, err)
+	}
+
+	_, err = GetResourceAccessWithContext(
+		cancelledCtx, testUsers["user-red"].KubeClient, MetricsACLConfig.groupRes, nil, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected err: %s got err: %v", context.Canceled, err)
+	}
+}
+
+func TestGetPrefixedAccess(t *testing.T) {
+	t.Parallel()
+
+	logsACLConfig := NewACLConfig("cluster.open-cluster-management.io", "managedclusters", "logs/")
+	resourceRules := []authorizationv1.ResourceRule{
+		{
+			Verbs:         []string{"logs/nsblue1", "metrics/nsred1"},
+			APIGroups:     []string{"cluster.open-cluster-management.io"},
+			Resources:     []string{"managedclusters"},
+			ResourceNames: []string{"devcluster1"},
+		},
+		{
+			Verbs:         []string{"*"},
+			APIGroups:     []string{"cluster.open-cluster-management.io"},
+			Resources:     []string{"managedclusters"},
+			ResourceNames: []string{"devcluster2"},
+		},
+	}
+
+	var calls int32
+
+	rbacEngine, err := NewAccessReviewer(nil, newFakeRulesClient(resourceRules, &calls))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	testcases := []struct {
+		cfg            ACLConfig
+		names          []string
+		expectedResult map[string][]string
+	}{
+		{logsACLConfig, nil, map[string][]string{"devcluster1": {"nsblue1"}, "devcluster2": {"*"}}},
+		{logsACLConfig, []string{"devcluster1", "devcluster3"}, map[string][]string{
+			"devcluster1": {"nsblue1"}, "devcluster3": {},
+		}},
+		{MetricsACLConfig, nil, map[string][]string{"devcluster1": {"nsred1"}, "devcluster2": {"*"}}},
+		{NewACLConfig("", "configmaps", "logs/"), nil, map[string][]string{}},
+	}
+
+	for _, test := range testcases {
+		gotResult, err := rbacEngine.GetPrefixedAccess(context.TODO(), "", test.cfg, test.names...)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !compareMetricsAccessResults(test.expectedResult, gotResult) {
+			t.Fatalf("expected result : %v , got  : %v", test.expectedResult, gotResult)
+		}
+	}
+
+	if logsACLConfig.GroupResource() != MetricsACLConfig.groupRes || logsACLConfig.VerbPrefix() != "logs/" {
+		t.Fatalf("expected ACLConfig for %v and logs/ , got  : %v and %s",
+			MetricsACLConfig.groupRes, logsACLConfig.GroupResource(), logsACLConfig.VerbPrefix())
+	}
+}
+
+func TestGetAnonymousKubeConfig(t *testing.T) {
+	t.Parallel()
+
+	rateLimiter := flowcontrol.NewFakeAlwaysRateLimiter()
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	errDial := errors.New("dial error")
+	kubeConfig := &rest.Config{
+		Host:    "https://hub.example.com:6443",
+		APIPath: "/api",
+		ContentConfig: rest.ContentConfig{
+			ContentType: "application/vnd.kubernetes.protobuf",
+		},
+		Username:        "hub-user",
+		Password:        "hub-password",
+		BearerToken:     "hub-token",
+		BearerTokenFile: "/var/run/secrets/hub-token",
+		Impersonate:     rest.ImpersonationConfig{UserName: "hub-impersonated-user"},
+		AuthProvider:    &clientcmdapi.AuthProviderConfig{Name: "oidc"},
+		ExecProvider:    &clientcmdapi.ExecConfig{Command: "hub-credentials"},
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure:   true,
+			ServerName: "hub.example.com",
+			CertFile:   "/etc/hub/tls.crt",
+			KeyFile:    "/etc/hub/tls.key",
+			CAFile:     "/etc/hub/ca.crt",
+			CertData:   []byte("hub-cert"),
+			KeyData:    []byte("hub-key"),
+			CAData:     []byte("hub-ca"),
+			NextProtos: []string{"http/1.1"},
+		},
+		UserAgent:          "rbac-api-utils-test",
+		DisableCompression: true,
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return rt
+		},
+		QPS:         50,
+		Burst:       100,
+		RateLimiter: rateLimiter,
+		Timeout:     42 * time.Second,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errDial
+		},
+		Proxy: http.ProxyURL(proxyURL),
+	}
+
+	rbacEngine, err := NewAccessReviewer(kubeConfig, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	gotConfig := rbacEngine.getAnonymousKubeConfig()
+
+	// transport and tuning settings are kept
+	if gotConfig.Host != kubeConfig.Host || gotConfig.APIPath != kubeConfig.APIPath {
+		t.Fatalf("expected host and api path : %s%s , got  : %s%s",
+			kubeConfig.Host, kubeConfig.APIPath, gotConfig.Host, gotConfig.APIPath)
+	}
+
+	if gotConfig.ContentType != kubeConfig.ContentType {
+		t.Fatalf("expected content type : %s , got  : %s", kubeConfig.ContentType, gotConfig.ContentType)
+	}
+
+	if !gotConfig.Insecure || gotConfig.ServerName != kubeConfig.ServerName ||
+		gotConfig.CAFile != kubeConfig.CAFile || string(gotConfig.CAData) != string(kubeConfig.CAData) ||
+		!slices.Equal(gotConfig.NextProtos, kubeConfig.NextProtos) {
+		t.Fatalf("expected TLS server settings : %v , got  : %v", kubeConfig.TLSClientConfig, gotConfig.TLSClientConfig)
+	}
+
+	if gotConfig.UserAgent != kubeConfig.UserAgent || !gotConfig.DisableCompression {
+		t.Fatalf("expected user agent : %s , got  : %s", kubeConfig.UserAgent, gotConfig.UserAgent)
+	}
+
+	if gotConfig.WrapTransport == nil {
+		t.Fatalf("expected WrapTransport to be set")
+	}
+
+	if gotConfig.QPS != kubeConfig.QPS || gotConfig.Burst != kubeConfig.Burst {
+		t.Fatalf("expected qps and burst : %v/%d , got  : %v/%d",
+			kubeConfig.QPS, kubeConfig.Burst, gotConfig.QPS, gotConfig.Burst)
+	}
+
+	if gotConfig.RateLimiter != rateLimiter {
+		t.Fatalf("expected rate limiter : %v , got  : %v", rateLimiter, gotConfig.RateLimiter)
+	}
+
+	if gotConfig.Timeout != kubeConfig.Timeout {
+		t.Fatalf("expected timeout : %s , got  : %s", kubeConfig.Timeout, gotConfig.Timeout)
+	}
+
+	if gotConfig.Dial == nil {
+		t.Fatalf("expected Dial to be set")
+	}
+
+	if _, err := gotConfig.Dial(context.TODO(), "tcp", "hub.example.com:6443"); !errors.Is(err, errDial) {
+		t.Fatalf("expected err: %s got err: %v", errDial, err)
+	}
+
+	if gotConfig.Proxy == nil {
+		t.Fatalf("expected Proxy to be set")
+	}
+
+	if gotProxyURL, _ := gotConfig.Proxy(&http.Request{}); gotProxyURL.String() != proxyURL.String() {
+		t.Fatalf("expected proxy : %s , got  : %s", proxyURL, gotProxyURL)
+	}
+
+	// credentials are dropped
+	if gotConfig.Username != "" || gotConfig.Password != "" ||
+		gotConfig.BearerToken != "" || gotConfig.BearerTokenFile != "" {
+		t.Fatalf("expected basic auth and token credentials to be dropped, got  : %v", gotConfig)
+	}
+
+	if gotConfig.CertFile != "" || gotConfig.KeyFile != "" || gotConfig.CertData != nil || gotConfig.KeyData != nil {
+		t.Fatalf("expected client certificates to be dropped, got  : %v", gotConfig.TLSClientConfig)
+	}
+
+	if gotConfig.AuthProvider != nil || gotConfig.ExecProvider != nil {
+		t.Fatalf("expected auth and exec providers to be dropped, got  : %v", gotConfig)
+	}
+
+	if gotConfig.Impersonate.UserName != "" {
+		t.Fatalf("expected impersonation to be dropped, got  : %v", gotConfig.Impersonate)
+	}
+}
+
+func TestUserKubeClientSettings(t *testing.T) {
+	t.Parallel()
+
+	fakeServer := newFakeRulesServer(t, map[string][]authorizationv1.ResourceRule{
+		"red-token": redMetricsRules,
+	})
+
+	var (
+		lock       sync.Mutex
+		userAgents []string
+	)
+
+	kubeConfig := &rest.Config{
+		Host:        fakeServer.URL,
+		UserAgent:   "rbac-api-utils-test",
+		BearerToken: "hub-token",
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				lock.Lock()
+				userAgents = append(userAgents, req.UserAgent())
+				lock.Unlock()
+
+				return rt.RoundTrip(req)
+			})
+		},
+	}
+
+	// the settings are applied to the clients created for the user with and without the client pool
+	for _, opts := range [][]Option{nil, {WithClientPool(0, 0)}} {
+		rbacEngine, err := NewAccessReviewer(kubeConfig, nil, opts...)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if _, err := rbacEngine.GetMetricsAccess("red-token"); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(userAgents) != 2 || userAgents[0] != "rbac-api-utils-test" || userAgents[1] != "rbac-api-utils-test" {
+		t.Fatalf("expected user agents : %s , got  : %v", "rbac-api-utils-test", userAgents)
+	}
+
+	fakeServer.lock.Lock()
+	defer fakeServer.lock.Unlock()
+
+	// the requests are made with the user's token, not the token of the k8s config
+	for _, token := range fakeServer.tokens {
+		if token != "red-token" {
+			t.Fatalf("expected token : %s , got  : %s", "red-token", token)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,92 @@
+package rbac
+
+import (
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// ResourceAccess holds the ACLs of the user for resources of a given type, as returned by GetResourceAccess.
+// The keys are resource names and the values are the verbs allowed on them. The "*" key holds the verbs
+// allowed on all resources of the type, and a "*" verb allows all verbs.
+//
+// As it is a map of resource names to verbs, it can be used as a map[string][]string.
+type ResourceAccess map[string][]string
+
+// Verbs returns the verbs allowed on the named resource, including the verbs allowed on all resources
+// of the type. A "*" verb in the results allows all verbs.
+func (a ResourceAccess) Verbs(name string) []string {
+	verbs := addUniqueItems([]string{}, a[name]...)
+
+	if name != "*" {
+		verbs = addUniqueItems(verbs, a["*"]...)
+	}
+
+	return verbs
+}
+
+// Allows returns true if the verb is allowed on the named resource, either explicitly or through
+// the verbs allowed on all resources of the type. The "*" verb in the ACLs allows any verb.
+func (a ResourceAccess) Allows(name string, verb string) bool {
+	return allowsVerb(a[name], verb) || allowsVerb(a["*"], verb)
+}
+
+// AllowsAll returns true if the verb is allowed on all resources of the type.
+func (a ResourceAccess) AllowsAll(verb string) bool {
+	return allowsVerb(a["*"], verb)
+}
+
+// Names returns the sorted names of the resources in the ACLs, excluding the "*" entry for all resources.
+// Names of requested resources without any allowed verbs are included.
+func (a ResourceAccess) Names() []string {
+	names := make([]string, 0, len(a))
+
+	for name := range a {
+		if name != "*" {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Merge returns the union of the ACLs with the other ACLs, leaving both unchanged.
+// Verbs are merged per resource name, and when a "*" verb is allowed for a name, the verbs for that name
+// are collapsed to just "*" since it allows all of them.
+func (a ResourceAccess) Merge(other ResourceAccess) ResourceAccess {
+	merged := make(ResourceAccess, len(a)+len(other))
+
+	for _, access := range []ResourceAccess{a, other} {
+		for name, verbs := range access {
+			merged.addVerbs(name, verbs...)
+		}
+	}
+
+	return merged
+}
+
+// addVerbs adds the verbs for the named resource that are not already in the ACLs,
+// collapsing the verbs to just "*" when it is added
+func (a ResourceAccess) addVerbs(name string, verbs ...string) {
+	switch {
+	case slices.Contains(a[name], "*"):
+		return
+	case slices.Contains(verbs, "*"):
+		a[name] = []string{"*"}
+	default:
+		// add verbs that are not already in the list
+		a[name] = addUniqueItems(a[name], verbs...)
+	}
+
+	// ensure there is an entry for the name even without verbs
+	if a[name] == nil {
+		a[name] = []string{}
+	}
+}
+
+// allowsVerb returns true if the verbs contain the verb or the "*" verb
+func allowsVerb(verbs []string, verb string) bool {
+	return slices.Contains(verbs, verb) || slices.Contains(verbs, "*")
+}
@@ -0,0 +1,309 @@
+package rbac
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestResourceAccess(t *testing.T) {
+	t.Parallel()
+
+	access := ResourceAccess{
+		"*":           {"list"},
+		"devcluster1": {"get", "metrics/nsred1"},
+		"devcluster2": {"*"},
+		"devcluster3": {},
+	}
+
+	testcases := []struct {
+		name            string
+		verb            string
+		expectedAllowed bool
+	}{
+		{"devcluster1", "get", true},
+		{"devcluster1", "list", true}, // allowed on all resources
+		{"devcluster1", "delete", false},
+		{"devcluster2", "delete", true}, // all verbs are allowed
+		{"devcluster3", "list", true},
+		{"devcluster3", "get", false},
+		{"unknown", "list", true},
+		{"unknown", "get", false},
+	}
+
+	for _, test := range testcases {
+		if gotAllowed := access.Allows(test.name, test.verb); gotAllowed != test.expectedAllowed {
+			t.Fatalf("expected %s allowed on %s : %t , got  : %t",
+				test.verb, test.name, test.expectedAllowed, gotAllowed)
+		}
+	}
+
+	if !access.AllowsAll("list") || access.AllowsAll("get") {
+		t.Fatalf("expected only list to be allowed on all resources, got  : %v", access["*"])
+	}
+
+	expectedVerbs := []string{"get", "metrics/nsred1", "list"}
+	if gotVerbs := access.Verbs("devcluster1"); !slices.Equal(expectedVerbs, gotVerbs) {
+		t.Fatalf("expected verbs : %v , got  : %v", expectedVerbs, gotVerbs)
+	}
+
+	expectedNames := []string{"devcluster1", "devcluster2", "devcluster3"}
+	if gotNames := access.Names(); !slices.Equal(expectedNames, gotNames) {
+		t.Fatalf("expected names : %v , got  : %v", expectedNames, gotNames)
+	}
+}
+
+func TestResourceAccessMerge(t *testing.T) {
+	t.Parallel()
+
+	access := ResourceAccess{
+		"devcluster1": {"get"},
+		"devcluster2": {"get", "list"},
+		"devcluster3": {},
+	}
+	other := ResourceAccess{
+		"*":           {"list"},
+		"devcluster1": {"get", "metrics/nsred1"},
+		"devcluster2": {"*"},
+	}
+
+	expectedMerged := ResourceAccess{
+		"*":           {"list"},
+		"devcluster1": {"get", "metrics/nsred1"},
+		"devcluster2": {"*"},
+		"devcluster3": {},
+	}
+
+	gotMerged := access.Merge(other)
+	if !compareMetricsAccessResults(expectedMerged, gotMerged) {
+		t.Fatalf("expected result : %v , got  : %v", expectedMerged, gotMerged)
+	}
+
+	if len(gotMerged["devcluster2"]) != 1 {
+		t.Fatalf("expected verbs collapsed to * , got  : %v", gotMerged["devcluster2"])
+	}
+
+	// the merged ACLs are left unchanged
+	if len(access["devcluster1"]) != 1 || len(other) != 3 {
+		t.Fatalf("expected the merged ACLs to be unchanged, got  : %v and %v", access, other)
+	}
+}
+
+func TestGetResourceAccessWildcards(t *testing.T) {
+	t.Parallel()
+
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+
+	access, err := GetResourceAccess(
+		testUsers["user-view-all-default-namespace"].KubeClient, configMaps, nil, "default")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !access.AllowsAll("get") || !access.Allows("any-configmap", "get") || access.Allows("any-configmap", "delete") {
+		t.Fatalf("expected get to be allowed on all configmaps, got  : %v", access)
+	}
+
+	access, err = GetResourceAccess(baseK8sClient, configMaps, []string{"cm1"}, "default")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !access.Allows("cm1", "delete") || !slices.Equal(access.Names(), []string{"cm1"}) {
+		t.Fatalf("expected all verbs to be allowed on cm1, got  : %v", access)
+	}
+}
+
+func TestGetResourceAccessForUser(t *testing.T) {
+	t.Parallel()
+
+	managedClusters := schema.GroupResource{Group: "cluster.open-cluster-management.io", Resource: "managedclusters"}
+
+	var directCalls int32
+
+	expectedAccess, err := GetResourceAccessWithContext(context.TODO(),
+		newFakeRulesClient(redMetricsRules, &directCalls), managedClusters, []string{"devcluster1"}, "ns1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var (
+		calls   int32
+		wg      sync.WaitGroup
+		release = make(chan struct{})
+	)
+
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "selfsubjectrulesreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+			review.Status.ResourceRules = redMetricsRules
+
+			return true, review, nil
+		})
+
+	rbacEngine, err := NewAccessReviewer(nil, fakeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	numCallers := 10
+	results := make([]ResourceAccess, numCallers)
+	errs := make([]error, numCallers)
+
+	for i := 0; i < numCallers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			results[i], errs[i] = rbacEngine.GetResourceAccessForUser(
+				context.TODO(), "", managedClusters, []string{"devcluster1"}, "ns1")
+		}(i)
+	}
+
+	// concurrent calls share a single rules review
+	waitForWaiters(t, rbacEngine.inflightReviews, hashToken(""), "ns1", numCallers)
+	close(release)
+	wg.Wait()
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expected num of rules reviews : %d , got  : %d", 1, atomic.LoadInt32(&calls))
+	}
+
+	// the ACLs are the same as the ones of GetResourceAccessWithContext
+	for i, result := range results {
+		if errs[i] != nil {
+			t.Fatalf(errs[i].Error())
+		}
+
+		if !reflect.DeepEqual(expectedAccess, result) {
+			t.Fatalf("expected access : %v , got  : %v", expectedAccess, result)
+		}
+	}
+}
+
+func TestGetResourceAccessSubresources(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		kubeClient    kubernetes.Interface
+		gr            schema.GroupResource
+		expectedVerbs []string
+	}{
+		{ // exact "resource/subresource" rule
+			testUsers["user-subresource-viewer"].KubeClient,
+			GroupSubresource("cluster.open-cluster-management.io", "managedclusters", "status"),
+			[]string{"get"},
+		},
+		{ // a rule for a subresource does not grant access to the resource
+			testUsers["user-subresource-viewer"].KubeClient,
+			schema.GroupResource{Group: "cluster.open-cluster-management.io", Resource: "managedclusters"},
+			[]string{},
+		},
+		{testUsers["user-subresource-viewer"].KubeClient, GroupSubresource("", "pods", "log"), []string{"get"}},
+		{testUsers["user-subresource-viewer"].KubeClient, GroupSubresource("", "pods", "exec"), []string{}},
+		{ // as in k8s RBAC, a "resource/*" rule does not match the subresources
+			testUsers["user-subresource-viewer"].KubeClient,
+			GroupSubresource("apps", "deployments", "status"),
+			[]string{},
+		},
+		{ // "*/subresource" rule
+			testUsers["user-subresource-viewer"].KubeClient,
+			GroupSubresource("apps", "deployments", "scale"),
+			[]string{"update"},
+		},
+		{ // "*/subresource" rule
+			testUsers["user-subresource-viewer"].KubeClient,
+			GroupSubresource("apps", "replicasets", "scale"),
+			[]string{"update"},
+		},
+		{
+			testUsers["user-subresource-viewer"].KubeClient,
+			schema.GroupResource{Group: "apps", Resource: "deployments"},
+			[]string{},
+		},
+		{baseK8sClient, GroupSubresource("", "pods", "log"), []string{"*"}}, // "*" rule matches subresources
+	}
+
+	for _, test := range testcases {
+		access, err := GetResourceAccess(test.kubeClient, test.gr, nil, "default")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		gotVerbs := access.Verbs("*")
+		slices.Sort(gotVerbs)
+
+		if !slices.Equal(test.expectedVerbs, gotVerbs) {
+			t.Fatalf("expected verbs on %s : %v , got  : %v", test.gr, test.expectedVerbs, gotVerbs)
+		}
+
+		// the ACLs match the access authorized by the server
+		rbacEngine, err := NewAccessReviewer(nil, test.kubeClient)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		resource, subresource, _ := strings.Cut(test.gr.Resource, "/")
+
+		for _, verb := range []string{"get", "update"} {
+			allowed, _, err := rbacEngine.CheckAccess(context.TODO(), "", authorizationv1.ResourceAttributes{
+				Namespace:   "default",
+				Verb:        verb,
+				Group:       test.gr.Group,
+				Resource:    resource,
+				Subresource: subresource,
+			})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			if allowed != access.AllowsAll(verb) {
+				t.Fatalf("expected %s on %s to be allowed : %t , got  : %t", verb, test.gr, allowed, access.AllowsAll(verb))
+			}
+		}
+	}
+}
+
+func TestResourceMatches(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		ruleResources   []string
+		resource        string
+		expectedMatches bool
+	}{
+		{[]string{"pods"}, "pods", true},
+		{[]string{"pods"}, "pods/log", false},
+		{[]string{"pods/log"}, "pods/log", true},
+		{[]string{"pods/log"}, "pods", false},
+		{[]string{"pods/*"}, "pods/log", false},
+		{[]string{"pods/*"}, "pods", false},
+		{[]string{"*/log"}, "pods/log", true},
+		{[]string{"*/log"}, "pods/exec", false},
+		{[]string{"*"}, "pods/log", true},
+		{[]string{"services", "pods/exec"}, "pods/exec", true},
+	}
+
+	for _, test := range testcases {
+		if gotMatches := resourceMatches(test.ruleResources, test.resource); gotMatches != test.expectedMatches {
+			t.Fatalf("expected %v to match %s : %t , got  : %t",
+				test.ruleResources, test.resource, test.expectedMatches, gotMatches)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// RulesReviewResult is the result of a SelfSubjectRulesReview of the user.
+type RulesReviewResult struct {
+	// ResourceRules are the rules of the user for the resources in the namespace of the review,
+	// including the cluster scoped resources
+	ResourceRules []authorizationv1.ResourceRule
+	// NonResourceRules are the rules of the user for the non-resource URLs, e.g. "/healthz"
+	NonResourceRules []authorizationv1.NonResourceRule
+	// Incomplete is true when the rules are incomplete, e.g. when an authorizer that doesn't support
+	// rules evaluation, such as a webhook, is configured on the k8s cluster
+	Incomplete bool
+	// EvaluationError is set when an error occurred while evaluating the rules. Together with
+	// Incomplete, it indicates that the rules may be partial.
+	EvaluationError string
+}
+
+// deepCopy returns a copy of the result that can be modified without affecting the original
+func (in *RulesReviewResult) deepCopy() *RulesReviewResult {
+	out := *in
+
+	if in.ResourceRules != nil {
+		out.ResourceRules = make([]authorizationv1.ResourceRule, len(in.ResourceRules))
+		for i := range in.ResourceRules {
+			in.ResourceRules[i].DeepCopyInto(&out.ResourceRules[i])
+		}
+	}
+
+	if in.NonResourceRules != nil {
+		out.NonResourceRules = make([]authorizationv1.NonResourceRule, len(in.NonResourceRules))
+		for i := range in.NonResourceRules {
+			in.NonResourceRules[i].DeepCopyInto(&out.NonResourceRules[i])
+		}
+	}
+
+	return &out
+}
+
+// WithStrictRules makes the AccessReviewer APIs that are based on the user's rules, e.g. GetMetricsAccess,
+// return an *IncompleteRulesError, matching ErrIncompleteRules, when the rules are incomplete,
+// instead of returning the access derived from the partial rules.
+func WithStrictRules() Option {
+	return func(r *AccessReviewer) {
+		r.strictRules = true
+	}
+}
+
+// GetRulesReview returns the result of the user's SelfSubjectRulesReview in the given namespace,
+// including whether the rules are incomplete. The rules cache is used if enabled on the AccessReviewer.
+// No error is returned for incomplete rules, even when strict rules are enabled, as the result flags them.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - namespace is used for namespace-scoped resources, if left empty only the rules for
+// cluster-scoped resources are returned.
+func (r *AccessReviewer) GetRulesReview(
+	ctx context.Context, userToken string, namespace string,
+) (*RulesReviewResult, error) {
+	klog.V(2).Infof("GetRulesReview for namespace: %s", namespace)
+
+	rulesReview, err := r.getRulesReviewForUser(ctx, userToken, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// the result may be shared with the cache and other callers
+	return rulesReview.deepCopy(), nil
+}
+
+// GetRulesReviews returns the normalized results of the user's SelfSubjectRulesReviews in each of the given
+// namespaces, e.g. to show the user why an action is allowed. The empty namespace returns the rules for
+// cluster-scoped resources. The reviews are made in parallel, with at most the number set by WithConcurrency
+// in-flight at a time, and the rules cache is used if enabled on the AccessReviewer.
+// If the review fails in any of the namespaces, an error is returned for the first of them in the given order.
+//
+// In the normalized results, the lists of each rule are sorted without duplicates, identical rules
+// are returned once and the rules are sorted, so that results can be compared and displayed consistently.
+//
+// - userToken is the user's OAuth bearer token, is required if k8s config was set on the AccessReviewer
+//
+// - namespaces are the namespaces the rules are returned for, duplicates are ignored.
+func (r *AccessReviewer) GetRulesReviews(
+	ctx context.Context, userToken string, namespaces ...string,
+) (map[string]*RulesReviewResult, error) {
+	klog.V(2).Infof("GetRulesReviews for namespaces: %v", namespaces)
+
+	namespaces = addUniqueItems([]string{}, namespaces...)
+	rulesReviews := make([]*RulesReviewResult, len(namespaces))
+	errs := make([]error, len(namespaces))
+
+	runConcurrently(len(namespaces), r.getConcurrency(), func(i int) {
+		rulesReviews[i], errs[i] = r.GetRulesReview(ctx, userToken, namespaces[i])
+	})
+
+	namespacesRulesReviews := make(map[string]*RulesReviewResult, len(namespaces))
+
+	for i, namespace := range namespaces {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to get the user's rules in namespace %s: %w", namespace, errs[i])
+		}
+
+		namespacesRulesReviews[namespace] = rulesReviews[i].normalize()
+	}
+
+	return namespacesRulesReviews, nil
+}
+
+// ResourceRulesGranting returns the rules that allow the verb on the named resource of the given type,
+// e.g. to show the user why an action is allowed. The resource type may be a subresource, see GroupSubresource.
+// If name is empty, the rules that allow the verb on all resources of the type are returned.
+// No rules are returned if the verb is not allowed.
+func (in *RulesReviewResult) ResourceRulesGranting(
+	gr schema.GroupResource, name string, verb string,
+) []authorizationv1.ResourceRule {
+	grantingRules := []authorizationv1.ResourceRule{}
+
+	for _, rule := range in.ResourceRules {
+		ruleMatchesAPIGroup := slices.Contains(rule.APIGroups, gr.Group) || slices.Contains(rule.APIGroups, "*")
+		// a rule with resource names only applies to those resources
+		ruleMatchesName := len(rule.ResourceNames) == 0 || (name != "" && slices.Contains(rule.ResourceNames, name))
+
+		if ruleMatchesAPIGroup && resourceMatches(rule.Resources, gr.Resource) && ruleMatchesName &&
+			allowsVerb(rule.Verbs, verb) {
+			grantingRules = append(grantingRules, rule)
+		}
+	}
+
+	return grantingRules
+}
+
+// NonResourceRulesGranting returns the rules that allow the verb on the non-resource URL path,
+// e.g. to show the user why an action is allowed. No rules are returned if the verb is not allowed.
+func (in *RulesReviewResult) NonResourceRulesGranting(path string, verb string) []authorizationv1.NonResourceRule {
+	grantingRules := []authorizationv1.NonResourceRule{}
+
+	for _, rule := range in.NonResourceRules {
+		if nonResourceURLMatches(rule.NonResourceURLs, path) && allowsVerb(rule.Verbs, verb) {
+			grantingRules = append(grantingRules, rule)
+		}
+	}
+
+	return grantingRules
+}
+
+// normalize sorts the lists of each rule without duplicates, removes identical rules and sorts the rules,
+// in place, and returns the result
+func (in *RulesReviewResult) normalize() *RulesReviewResult {
+	resourceRules := make(map[string]authorizationv1.ResourceRule, len(in.ResourceRules))
+
+	for _, rule := range in.ResourceRules {
+		rule.Verbs = sortedUniqueItems(rule.Verbs)
+		rule.APIGroups = sortedUniqueItems(rule.APIGroups)
+		rule.Resources = sortedUniqueItems(rule.Resources)
+		rule.ResourceNames = sortedUniqueItems(rule.ResourceNames)
+
+		resourceRules[strings.Join([]string{
+			strings.Join(rule.APIGroups, ","), strings.Join(rule.Resources, ","),
+			strings.Join(rule.ResourceNames, ","), strings.Join(rule.Verbs, ","),
+		}, " ")] = rule
+	}
+
+	in.ResourceRules = make([]authorizationv1.ResourceRule, 0, len(resourceRules))
+	for _, key := range sortedKeys(resourceRules) {
+		in.ResourceRules = append(in.ResourceRules, resourceRules[key])
+	}
+
+	nonResourceRules := make(map[string]authorizationv1.NonResourceRule, len(in.NonResourceRules))
+
+	for _, rule := range in.NonResourceRules {
+		rule.Verbs = sortedUniqueItems(rule.Verbs)
+		rule.NonResourceURLs = sortedUniqueItems(rule.NonResourceURLs)
+
+		nonResourceRules[strings.Join(rule.NonResourceURLs, ",")+" "+strings.Join(rule.Verbs, ",")] = rule
+	}
+
+	in.NonResourceRules = make([]authorizationv1.NonResourceRule, 0, len(nonResourceRules))
+	for _, key := range sortedKeys(nonResourceRules) {
+		in.NonResourceRules = append(in.NonResourceRules, nonResourceRules[key])
+	}
+
+	return in
+}
+
+// sortedUniqueItems returns a sorted copy of the items without duplicates, or nil if there are no items
+func sortedUniqueItems(items []string) []string {
+	if len(items) == 0 {
+		return nil
+	}
+
+	sortedItems := addUniqueItems([]string{}, items...)
+	sort.Strings(sortedItems)
+
+	return sortedItems
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,236 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeIncompleteRulesClient returns a fake k8s client that responds to SelfSubjectRulesReviews with
+// the given rules flagged as incomplete, with the given evaluation error
+func newFakeIncompleteRulesClient(
+	resourceRules []authorizationv1.ResourceRule, evaluationError string,
+) kubernetes.Interface {
+	fakeClient := fake.NewSimpleClientset()
+	fakeClient.PrependReactor("create", "selfsubjectrulesreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+			review.Status.ResourceRules = resourceRules
+			review.Status.Incomplete = true
+			review.Status.EvaluationError = evaluationError
+
+			return true, review, nil
+		})
+
+	return fakeClient
+}
+
+func TestGetRulesReview(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		username           string
+		kubeClient         kubernetes.Interface
+		namespace          string
+		expectedNumRules   int
+		expectedIncomplete bool
+	}{
+		{"user-purple", testUsers["user-purple"].KubeClient, "", 3, false},
+		{
+			"user-view-all-default-namespace",
+			testUsers["user-view-all-default-namespace"].KubeClient,
+			"default",
+			2,
+			false,
+		},
+		{"user-webhook", newFakeIncompleteRulesClient(redMetricsRules, "webhook authorizer"), "", 1, true},
+	}
+
+	for _, test := range testcases {
+		rbacEngine, err := NewAccessReviewer(nil, test.kubeClient, WithStrictRules())
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		rulesReview, err := rbacEngine.GetRulesReview(context.TODO(), "", test.namespace)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if test.expectedNumRules != len(rulesReview.ResourceRules) {
+			t.Fatalf("user %s: expected num of access rules : %d , got  : %d",
+				test.username, test.expectedNumRules, len(rulesReview.ResourceRules))
+		}
+
+		if test.expectedIncomplete != rulesReview.Incomplete {
+			t.Fatalf("user %s: expected incomplete : %t , got  : %t",
+				test.username, test.expectedIncomplete, rulesReview.Incomplete)
+		}
+	}
+}
+
+func TestGetMetricsAccessWithIncompleteRules(t *testing.T) {
+	t.Parallel()
+
+	kubeClient := newFakeIncompleteRulesClient(redMetricsRules, "webhook authorizer")
+
+	// without strict rules, the access derived from the partial rules is returned
+	rbacEngine, err := NewAccessReviewer(nil, kubeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expectedResult := map[string][]string{"devcluster1": {"nsred1", "nsred2"}}
+
+	gotResult, err := rbacEngine.GetMetricsAccess("", "devcluster1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !compareMetricsAccessResults(expectedResult, gotResult) {
+		t.Fatalf("expected result : %v , got  : %v", expectedResult, gotResult)
+	}
+
+	// with strict rules, an error is returned
+	rbacEngine, err = NewAccessReviewer(nil, kubeClient, WithStrictRules())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err = rbacEngine.GetMetricsAccess("", "devcluster1")
+	if !errors.Is(err, ErrIncompleteRules) {
+		t.Fatalf("expected err: %s got err: %v", ErrIncompleteRules, err)
+	}
+
+	var incompleteErr *IncompleteRulesError
+	if !errors.As(err, &incompleteErr) || incompleteErr.EvaluationError != "webhook authorizer" {
+		t.Fatalf("expected err with evaluation error: %s got err: %v", "webhook authorizer", err)
+	}
+}
+
+func TestGetRulesReviews(t *testing.T) {
+	t.Parallel()
+
+	rbacEngine, err := NewAccessReviewer(nil, testUsers["user-view-all-default-namespace"].KubeClient)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rulesReviews, err := rbacEngine.GetRulesReviews(context.TODO(), "", "", "default")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+
+	if rules := rulesReviews[""].ResourceRulesGranting(configMaps, "cm1", "get"); len(rules) != 0 {
+		t.Fatalf("expected no rules granting get on configmaps for cluster scope, got  : %v", rules)
+	}
+
+	rules := rulesReviews["default"].ResourceRulesGranting(configMaps, "cm1", "get")
+	if len(rules) != 1 || !slices.Equal(rules[0].Resources, []string{"*"}) {
+		t.Fatalf("expected the view-all rule granting get on configmaps, got  : %v", rules)
+	}
+
+	if rules := rulesReviews["default"].NonResourceRulesGranting("/healthz", "get"); len(rules) == 0 {
+		t.Fatalf("expected rules granting get on /healthz, got  : %v", rules)
+	}
+}
+
+func TestRulesReviewResultNormalize(t *testing.T) {
+	t.Parallel()
+
+	resourceRules := []authorizationv1.ResourceRule{
+		{Verbs: []string{"list", "get", "get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+		{
+			Verbs:         []string{"metrics/nsred2", "metrics/nsred1"},
+			APIGroups:     []string{"cluster.open-cluster-management.io"},
+			Resources:     []string{"managedclusters"},
+			ResourceNames: []string{"devcluster2", "devcluster1"},
+		},
+		{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+	}
+
+	var calls int32
+
+	rbacEngine, err := NewAccessReviewer(nil, newFakeRulesClient(resourceRules, &calls))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rulesReviews, err := rbacEngine.GetRulesReviews(context.TODO(), "", "ns1", "ns1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(rulesReviews) != 1 || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expected a single rules review for ns1, got  : %v", rulesReviews)
+	}
+
+	gotRules := rulesReviews["ns1"].ResourceRules
+	if len(gotRules) != 2 {
+		t.Fatalf("expected num of access rules : %d , got  : %d", 2, len(gotRules))
+	}
+
+	if !slices.Equal(gotRules[0].Verbs, []string{"get", "list"}) ||
+		!slices.Equal(gotRules[1].ResourceNames, []string{"devcluster1", "devcluster2"}) {
+		t.Fatalf("expected sorted rules, got  : %v", gotRules)
+	}
+
+	// the rules returned by the review are left unchanged
+	if !slices.Equal(resourceRules[0].Verbs, []string{"list", "get", "get"}) {
+		t.Fatalf("expected the reviewed rules to be unchanged, got  : %v", resourceRules[0])
+	}
+}
+
+func TestRulesGranting(t *testing.T) {
+	t.Parallel()
+
+	rulesReview := &RulesReviewResult{
+		ResourceRules: append([]authorizationv1.ResourceRule{
+			{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*/status"}},
+		}, redMetricsRules...),
+		NonResourceRules: []authorizationv1.NonResourceRule{
+			{Verbs: []string{"get"}, NonResourceURLs: []string{"/apis/*"}},
+		},
+	}
+
+	testcases := []struct {
+		gr                 schema.GroupResource
+		name               string
+		verb               string
+		expectedNumGranted int
+	}{
+		{MetricsACLConfig.groupRes, "devcluster1", "metrics/nsred1", 1},
+		{MetricsACLConfig.groupRes, "devcluster3", "metrics/nsred1", 0},
+		{MetricsACLConfig.groupRes, "", "metrics/nsred1", 0}, // not allowed on all clusters
+		{MetricsACLConfig.groupRes, "devcluster1", "get", 0},
+		{GroupSubresource("cluster.open-cluster-management.io", "managedclusters", "status"), "devcluster1", "get", 1},
+		{GroupSubresource("cluster.open-cluster-management.io", "managedclusters", "status"), "", "update", 1},
+	}
+
+	for _, test := range testcases {
+		rules := rulesReview.ResourceRulesGranting(test.gr, test.name, test.verb)
+		if len(rules) != test.expectedNumGranted {
+			t.Fatalf("expected num of rules granting %s on %s %s : %d , got  : %v",
+				test.verb, test.gr, test.name, test.expectedNumGranted, rules)
+		}
+	}
+
+	if rules := rulesReview.NonResourceRulesGranting("/apis/apps", "get"); len(rules) != 1 {
+		t.Fatalf("expected num of rules granting get on /apis/apps : %d , got  : %v", 1, rules)
+	}
+
+	if rules := rulesReview.NonResourceRulesGranting("/apis/apps", "post"); len(rules) != 0 {
+		t.Fatalf("expected num of rules granting post on /apis/apps : %d , got  : %v", 0, rules)
+	}
+}
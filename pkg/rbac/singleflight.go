@@ -0,0 +1,125 @@
+package rbac
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// rulesReviewGroup de-duplicates concurrent SelfSubjectRulesReviews made for the same user and namespace,
+// so that only one call is in-flight to the k8s cluster and its result is shared by all the callers.
+// The zero value is ready to use.
+type rulesReviewGroup struct {
+	lock  sync.Mutex
+	calls map[rulesCacheKey]*rulesReviewCall
+}
+
+// rulesReviewCall is an in-flight SelfSubjectRulesReview
+type rulesReviewCall struct {
+	// done is closed once the call completes and the results are set
+	done chan struct{}
+	// waiters is the number of callers waiting for the results, it is guarded by the group lock
+	waiters int
+	// cancel cancels the call, it is invoked once no callers are waiting for the results
+	cancel context.CancelFunc
+
+	rulesReview *RulesReviewResult
+	err         error
+}
+
+// do runs reviewFunc for the user's token hash and namespace, unless a call for them is already in-flight,
+// in which case it waits for and returns the results of that call.
+//
+// Each caller's context is honored: if it is done before the results are available, ctx.Err() is returned
+// to that caller. The in-flight call itself is only cancelled once all the callers waiting on it are gone.
+// Values of the context of the caller that starts the call are available to reviewFunc.
+func (g *rulesReviewGroup) do(
+	ctx context.Context, tokenHash string, namespace string,
+	reviewFunc func(context.Context) (*RulesReviewResult, error),
+) (*RulesReviewResult, error) {
+	key := rulesCacheKey{tokenHash: tokenHash, namespace: namespace}
+
+	g.lock.Lock()
+
+	if g.calls == nil {
+		g.calls = make(map[rulesCacheKey]*rulesReviewCall)
+	}
+
+	call, ok := g.calls[key]
+	if ok {
+		klog.V(2).Infof("Joining the in-flight rules review for namespace %s", namespace)
+
+		call.waiters++
+	} else {
+		callCtx, cancel := context.WithCancel(valuesOnlyContext{parent: ctx})
+		call = &rulesReviewCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = call
+
+		go g.run(callCtx, key, call, reviewFunc)
+	}
+
+	g.lock.Unlock()
+
+	select {
+	case <-call.done:
+		return call.rulesReview, call.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		defer g.lock.Unlock()
+
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody is waiting for the results anymore, so cancel the call and forget it
+			// to ensure new callers don't join a cancelled call
+			call.cancel()
+			g.forget(key, call)
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// run makes the call and publishes its results to the callers waiting on it
+func (g *rulesReviewGroup) run(
+	ctx context.Context, key rulesCacheKey, call *rulesReviewCall,
+	reviewFunc func(context.Context) (*RulesReviewResult, error),
+) {
+	call.rulesReview, call.err = reviewFunc(ctx)
+	call.cancel()
+
+	g.lock.Lock()
+	g.forget(key, call)
+	g.lock.Unlock()
+
+	close(call.done)
+}
+
+// forget removes the call from the in-flight calls if it is still registered, the lock must be held by the caller
+func (g *rulesReviewGroup) forget(key rulesCacheKey, call *rulesReviewCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// valuesOnlyContext carries the values of its parent context, but not its deadline or cancellation
+type valuesOnlyContext struct {
+	parent context.Context
+}
+
+func (valuesOnlyContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (valuesOnlyContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valuesOnlyContext) Err() error {
+	return nil
+}
+
+func (c valuesOnlyContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
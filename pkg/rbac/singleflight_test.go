@@ -0,0 +1,156 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until the given number of callers are waiting on the in-flight call
+func waitForWaiters(t *testing.T, group *rulesReviewGroup, tokenHash string, namespace string, waiters int) {
+	t.Helper()
+
+	key := rulesCacheKey{tokenHash: tokenHash, namespace: namespace}
+
+	for i := 0; i < 500; i++ {
+		group.lock.Lock()
+		call, ok := group.calls[key]
+		gotWaiters := 0
+
+		if ok {
+			gotWaiters = call.waiters
+		}
+		group.lock.Unlock()
+
+		if gotWaiters == waiters {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %d callers on the in-flight call", waiters)
+}
+
+func TestRulesReviewGroup(t *testing.T) {
+	t.Parallel()
+
+	var (
+		group   rulesReviewGroup
+		calls   int32
+		wg      sync.WaitGroup
+		release = make(chan struct{})
+	)
+
+	reviewFunc := func(ctx context.Context) (*RulesReviewResult, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+
+		return redMetricsReview, nil
+	}
+
+	numCallers := 30
+	results := make([]*RulesReviewResult, numCallers)
+
+	for i := 0; i < numCallers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			results[i], _ = group.do(context.TODO(), "token1", "", reviewFunc)
+		}(i)
+	}
+
+	waitForWaiters(t, &group, "token1", "", numCallers)
+	close(release)
+	wg.Wait()
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expected num of rules reviews : %d , got  : %d", 1, atomic.LoadInt32(&calls))
+	}
+
+	for _, result := range results {
+		if result != redMetricsReview {
+			t.Fatalf("expected result : %v , got  : %v", redMetricsReview, result)
+		}
+	}
+}
+
+func TestRulesReviewGroupCancellation(t *testing.T) {
+	t.Parallel()
+
+	var group rulesReviewGroup
+
+	reviewCancelled := make(chan struct{})
+	release := make(chan struct{})
+
+	reviewFunc := func(ctx context.Context) (*RulesReviewResult, error) {
+		<-release
+
+		return redMetricsReview, nil
+	}
+
+	cancelledCtx, cancelFunc := context.WithCancel(context.TODO())
+	cancelledErr := make(chan error)
+
+	go func() {
+		_, err := group.do(cancelledCtx, "token1", "", reviewFunc)
+		cancelledErr <- err
+	}()
+
+	waitForWaiters(t, &group, "token1", "", 1)
+
+	resultCh := make(chan *RulesReviewResult)
+
+	go func() {
+		result, _ := group.do(context.TODO(), "token1", "", reviewFunc)
+		resultCh <- result
+	}()
+
+	waitForWaiters(t, &group, "token1", "", 2)
+
+	// the first caller goes away, but the call stays in-flight for the second caller
+	cancelFunc()
+
+	if err := <-cancelledErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected err: %s got err: %v", context.Canceled, err)
+	}
+
+	close(release)
+
+	if result := <-resultCh; result != redMetricsReview {
+		t.Fatalf("expected result : %v , got  : %v", redMetricsReview, result)
+	}
+
+	// the call is cancelled once its only caller goes away
+	cancelledCtx, cancelFunc = context.WithCancel(context.TODO())
+
+	blockingReviewFunc := func(ctx context.Context) (*RulesReviewResult, error) {
+		<-ctx.Done()
+		close(reviewCancelled)
+
+		return nil, ctx.Err()
+	}
+
+	go func() {
+		_, err := group.do(cancelledCtx, "token2", "", blockingReviewFunc)
+		cancelledErr <- err
+	}()
+
+	waitForWaiters(t, &group, "token2", "", 1)
+	cancelFunc()
+
+	if err := <-cancelledErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected err: %s got err: %v", context.Canceled, err)
+	}
+
+	select {
+	case <-reviewCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the in-flight rules review to be cancelled")
+	}
+}